@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"highspot/data"
+	"highspot/data/file"
+	"highspot/data/sqlite"
+	"highspot/resources"
+	"log"
+	"os"
+)
+
+// The db subcommands, each parses its own arguments
+var dbCommands = map[string]func(args []string){
+	"import": runDbImport,
+	"apply":  runDbApply,
+	"export": runDbExport,
+}
+
+// The db subcommand keeps a mixtape in a SQLite database, which can be queried with SQL
+func runDb(args []string) {
+	if len(args) == 0 || dbCommands[args[0]] == nil {
+		printDbUsage()
+		os.Exit(2)
+	}
+	dbCommands[args[0]](args[1:])
+}
+
+func printDbUsage() {
+	fmt.Print("Keep a mixtape in a SQLite database.\n\n")
+	fmt.Print("Usage: highspot db import [arguments]   Import the input file into a new database.\n")
+	fmt.Print("       highspot db apply [arguments]    Apply the changes file to the database.\n")
+	fmt.Print("       highspot db export [arguments]   Export the database to the output file.\n\n")
+	fmt.Print("Run highspot db <command> -h for the arguments of a command.\n")
+}
+
+func newDbFlagSet(command, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet("db "+command, flag.ExitOnError)
+	flags.StringVar(&cmdline.Database, "db", "mixtape.db", "The database file path.")
+	flags.StringVar(&cmdline.ErrorForm, "e", "text", "The error output form, text or json.")
+	flags.Usage = func() {
+		fmt.Printf("%v\n\n", description)
+		fmt.Printf("Usage: highspot db %v [arguments]\n\n", command)
+		fmt.Print("The arguments are:\n\n")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Import the input file into a new database, an existing database file is replaced. The input
+// file is streamed into the database in a single transaction.
+func runDbImport(args []string) {
+	flags := newDbFlagSet("import", "Import the input file into a new database, the database file is replaced.")
+	flags.StringVar(&cmdline.InputPath, "p", "mixtape.json", "The input file path, - for the standard input. A gzip, zstd or bzip2 file is decompressed.")
+	flags.StringVar(&cmdline.IngestMode, "i", "lenient", "The input mode for invalid playlists, strict, lenient or repair.")
+	flags.StringVar(&cmdline.ReportPath, "r", "", "The report file path.")
+	flags.Parse(args)
+
+	ingestMode, err := resources.ParseIngestMode(cmdline.IngestMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	store, err := sqlite.Create(cmdline.Database)
+	if err != nil {
+		log.Fatalf("Cannot create database file. %v", err)
+	}
+
+	report := &data.Report{Ingestion: []resources.Issue{}, Changes: []data.ChangeResult{}}
+	err = store.Update(func(storage resources.Storage) error {
+		input, err := file.NewClient(cmdline.InputPath).Open()
+		if err != nil {
+			return fmt.Errorf("Cannot read input file. %w.", err)
+		}
+		defer input.Close()
+
+		mixtape := resources.NewMixTapeWithStorage(storage)
+		mixtape.SetIngestMode(ingestMode)
+		err = data.StreamMixTape(input, mixtape)
+		if err != nil {
+			return fmt.Errorf("Invalid input file. %w", err)
+		}
+
+		report.Ingestion = mixtape.IngestionIssues()
+		return nil
+	})
+	store.Close()
+
+	if err != nil {
+		// The database file is not left empty
+		os.Remove(cmdline.Database)
+		exitWithError(fmt.Errorf("Import failed. %w", err))
+	}
+
+	for _, issue := range report.Ingestion {
+		log.Printf("Playlist ID %v %v. %v", issue.PlayListID, issue.Action, issue.Message)
+	}
+	writeDbReport(report)
+
+	log.Printf("The input file %v was imported into the database %v.", cmdline.InputPath, cmdline.Database)
+}
+
+// Apply the changes file to the database in a single transaction. The changes are either
+// all written, or not written at all when the apply fails.
+func runDbApply(args []string) {
+	flags := newDbFlagSet("apply", "Apply the changes file to the database in a transaction.")
+	flags.StringVar(&cmdline.Changes, "c", "changes.json", "The changes file, - for the standard input.")
+	flags.StringVar(&cmdline.Format, "cf", "detect", "The changes file format, json-patch, merge-patch, or detect from the document.")
+	flags.StringVar(&cmdline.ApplyMode, "a", "transactional", "The apply mode, transactional or best-effort.")
+	flags.StringVar(&cmdline.RefPolicy, "refs", "reject", "The policy for the playlists of a removed user or song, reject, cascade or strip.")
+	flags.StringVar(&cmdline.ReportPath, "r", "", "The report file path.")
+	flags.StringVar(&cmdline.UndoPath, "undo", "", "The undo file path, the changes that turn the database back into the database before the changes.")
+	flags.Parse(args)
+
+	applyMode, err := data.ParseApplyMode(cmdline.ApplyMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	refPolicy, err := resources.ParseReferencePolicy(cmdline.RefPolicy)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	changesFormat, err := data.ParseChangesFormat(cmdline.Format)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	changesData, err := file.NewClient(cmdline.Changes).Read()
+	if err != nil {
+		exitWithError(fmt.Errorf("Cannot read changes file. %w", err))
+	}
+
+	store, err := sqlite.Open(cmdline.Database)
+	if err != nil {
+		log.Fatalf("Cannot open database file. %v", err)
+	}
+	defer store.Close()
+
+	report := &data.Report{Ingestion: []resources.Issue{}}
+	var undo []resources.Change
+	var changesErr error
+	err = store.Update(func(storage resources.Storage) error {
+		mixtape := resources.NewMixTapeWithStorage(storage)
+		mixtape.SetReferencePolicy(refPolicy)
+
+		// A merge patch is translated against the mixtape in the database
+		changes, err := data.DecodeChangesFormat(changesData, changesFormat, mixtape)
+		if err != nil {
+			changesErr = err
+			return err
+		}
+
+		if len(cmdline.UndoPath) != 0 {
+			report.Changes, undo, err = data.ApplyChangesWithUndo(mixtape, changes, applyMode)
+			return err
+		}
+
+		report.Changes, err = data.ApplyChanges(mixtape, changes, applyMode)
+		return err
+	})
+
+	if changesErr != nil {
+		store.Close()
+		exitWithError(fmt.Errorf("Invalid changes file. %w", changesErr))
+	}
+
+	if report.Changes != nil {
+		writeDbReport(report)
+	}
+
+	if err != nil {
+		store.Close()
+		exitWithError(fmt.Errorf("Cannot apply changes. %w", err))
+	}
+
+	// The undo file is written once the changes are committed to the database
+	if undo != nil {
+		undoJSON, err := data.EncodeChanges(undo)
+		if err == nil {
+			err = file.NewClient(cmdline.UndoPath).Write(undoJSON)
+		}
+		if err != nil {
+			store.Close()
+			exitWithError(fmt.Errorf("Cannot write undo file. %w", err))
+		}
+	}
+
+	log.Printf("The changes file %v was applied to the database %v.", cmdline.Changes, cmdline.Database)
+}
+
+// Export the database to the output file, in the input file format
+func runDbExport(args []string) {
+	flags := newDbFlagSet("export", "Export the database to the output file.")
+	flags.StringVar(&cmdline.OutputPath, "o", "output.json", "The output file path, - for the standard output. A .gz, .zst or .bz2 file is compressed.")
+	flags.StringVar(&cmdline.SortMode, "s", "insertion", "The output order, insertion, id or user.")
+	flags.Parse(args)
+
+	sortMode, err := resources.ParseSortMode(cmdline.SortMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	store, err := sqlite.Open(cmdline.Database)
+	if err != nil {
+		log.Fatalf("Cannot open database file. %v", err)
+	}
+
+	// The mixtape is read in a transaction, so it is a consistent snapshot of the database
+	err = store.Update(func(storage resources.Storage) error {
+		mixtape := resources.NewMixTapeWithStorage(storage)
+		mixtape.SetSortMode(sortMode)
+		return data.WriteOutput(file.NewClient(cmdline.OutputPath), mixtape)
+	})
+	store.Close()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	log.Printf("The database %v was exported to the output file %v.", cmdline.Database, cmdline.OutputPath)
+}
+
+func writeDbReport(report *data.Report) {
+	if len(cmdline.ReportPath) == 0 {
+		return
+	}
+
+	err := writeReport(report)
+	if err != nil {
+		log.Printf("Cannot write report file. %v", err)
+	}
+}
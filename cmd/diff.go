@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"highspot/data"
+	"highspot/data/file"
+	"highspot/resources"
+	"log"
+)
+
+// The diff subcommand writes the changes file that turns the input file into the target file.
+// The changes are verified before they are written, applied to the input file they must
+// produce the target file.
+func runDiff(args []string) {
+	var targetPath string
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.StringVar(&cmdline.InputPath, "p", "mixtape.json", "The input file path, the mixtape before the changes. - for the standard input.")
+	flags.StringVar(&targetPath, "t", "output.json", "The target file path, the mixtape after the changes. - for the standard input.")
+	flags.StringVar(&cmdline.Changes, "c", "changes.json", "The changes file path, - for the standard output. A .gz, .zst or .bz2 file is compressed.")
+	flags.StringVar(&cmdline.IngestMode, "i", "strict", "The input mode for invalid playlists, strict, lenient or repair.")
+	flags.StringVar(&cmdline.ErrorForm, "e", "text", "The error output form, text or json.")
+	flags.Usage = func() {
+		fmt.Print("Write the changes file that turns the input file into the target file.\n\n")
+		fmt.Print("Usage: highspot diff [arguments]\n\n")
+		fmt.Print("The arguments are:\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ingestMode, err := resources.ParseIngestMode(cmdline.IngestMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	if cmdline.InputPath == file.Std && targetPath == file.Std {
+		log.Fatalf("Invalid arguments. The input file and the target file cannot both be the standard input.")
+	}
+
+	before, err := readMixTape(cmdline.InputPath, ingestMode)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	after, err := readMixTape(targetPath, ingestMode)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	changes, err := data.Diff(before, after)
+	if err != nil {
+		exitWithError(fmt.Errorf("Cannot compare the input and target files. %w", err))
+	}
+
+	err = data.VerifyDiff(before, after, changes)
+	if err != nil {
+		exitWithError(fmt.Errorf("Cannot verify the changes. %w", err))
+	}
+
+	changesJSON, err := data.EncodeChanges(changes)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	err = file.NewClient(cmdline.Changes).Write(changesJSON)
+	if err != nil {
+		exitWithError(fmt.Errorf("Cannot write changes file. %w", err))
+	}
+
+	log.Printf("The changes file %v has %v changes from %v to %v.", cmdline.Changes, len(changes), cmdline.InputPath, targetPath)
+}
+
+// Read and decode a mixtape file, the ingestion issues are logged
+func readMixTape(path string, ingestMode resources.IngestMode) (*resources.MixTape, error) {
+	input, err := file.NewClient(path).Read()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read file %v. %w", path, err)
+	}
+
+	mixtape, err := data.DecodeMixTape(input, ingestMode)
+	if err != nil {
+		return nil, fmt.Errorf("File %v. %w", path, err)
+	}
+	for _, issue := range mixtape.IngestionIssues() {
+		log.Printf("%v: Playlist ID %v %v. %v", path, issue.PlayListID, issue.Action, issue.Message)
+	}
+
+	return mixtape, nil
+}
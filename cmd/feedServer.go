@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"highspot/data"
+	"highspot/data/file"
+	"highspot/resources"
+	"highspot/server"
+	"log"
+	"net/http"
+)
+
+// The feed-server subcommand serves the input file as the paginated feed
+func runFeedServer(args []string) {
+	var address string
+
+	flags := flag.NewFlagSet("feed-server", flag.ExitOnError)
+	flags.StringVar(&address, "addr", "localhost:8080", "The address the server listens on.")
+	flags.StringVar(&cmdline.InputPath, "p", "mixtape.json", "The input file path.")
+	flags.StringVar(&cmdline.IngestMode, "i", "lenient", "The input mode for invalid playlists, strict, lenient or repair.")
+	flags.StringVar(&cmdline.ApplyMode, "a", "transactional", "The apply mode of the changes, transactional or best-effort.")
+	flags.StringVar(&cmdline.RefPolicy, "refs", "reject", "The policy for the playlists of a removed user or song, reject, cascade or strip.")
+	flags.Usage = func() {
+		fmt.Print("Serve the input file as the paginated feed.\n\n")
+		fmt.Print("Usage: highspot feed-server [arguments]\n\n")
+		fmt.Print("The arguments are:\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ingestMode, err := resources.ParseIngestMode(cmdline.IngestMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	applyMode, err := data.ParseApplyMode(cmdline.ApplyMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	refPolicy, err := resources.ParseReferencePolicy(cmdline.RefPolicy)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	input, err := file.NewClient(cmdline.InputPath).Read()
+	if err != nil {
+		log.Fatalf("Cannot read input file. %v", err)
+	}
+
+	mixtape, err := data.DecodeMixTape(input, ingestMode)
+	if err != nil {
+		exitWithError(err)
+	}
+	for _, issue := range mixtape.IngestionIssues() {
+		log.Printf("Playlist ID %v %v. %v", issue.PlayListID, issue.Action, issue.Message)
+	}
+	mixtape.SetReferencePolicy(refPolicy)
+
+	feedServer, err := server.NewFeedServer(mixtape, applyMode)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	log.Printf("Serving the feed of %v on %v.", cmdline.InputPath, address)
+	log.Fatal(http.ListenAndServe(address, feedServer))
+}
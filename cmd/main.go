@@ -1,11 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"highspot/data"
 	"highspot/data/file"
 	"highspot/data/http"
+	"highspot/data/kv"
+	"highspot/data/validation"
+	"highspot/resources"
 	"log"
 	"os"
 )
@@ -14,14 +19,66 @@ type CommandLine struct {
 	InputUrl   string
 	InputPath  string
 	Changes    string
+	Format     string
 	OutputPath string
+	ReportPath string
+	UndoPath   string
+	ApplyMode  string
+	SortMode   string
+	RefPolicy  string
+	IngestMode string
+	ErrorForm  string
+	Retries    int
+	CacheDir   string
+	Headers    headerList
+	TokenEnv   string
+	TokenFile  string
+	BasicUser  string
+	BasicEnv   string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	Cursors    string
+	Limit      int
+	Stream     bool
+	StorePath  string
+	Database   string
 	Help       bool
 }
 
 var cmdline = CommandLine{}
 
+// The subcommands, each parses its own arguments
+var commands = map[string]func(args []string){
+	"sync":        runSync,
+	"feed-server": runFeedServer,
+	"db":          runDb,
+	"serve":       runServe,
+	"diff":        runDiff,
+}
+
+// The -header arguments, the flag can be repeated
+type headerList []string
+
+func (h *headerList) String() string {
+	return fmt.Sprintf("%v headers", len(*h))
+}
+
+func (h *headerList) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
 // The main program.
 func main() {
+	// Run a subcommand.
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse the command line arguments.
 	flag.Parse()
 
@@ -35,37 +92,223 @@ func main() {
 	// Create an ingester and execute the take-home exercise
 	//
 
-	ingester := data.NewIngestor(getInputReader(), file.NewClient(cmdline.Changes), file.NewClient(cmdline.OutputPath))
+	applyMode, err := data.ParseApplyMode(cmdline.ApplyMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	sortMode, err := resources.ParseSortMode(cmdline.SortMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	refPolicy, err := resources.ParseReferencePolicy(cmdline.RefPolicy)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	ingestMode, err := resources.ParseIngestMode(cmdline.IngestMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
 
-	err := ingester.Execute()
+	changesFormat, err := data.ParseChangesFormat(cmdline.Format)
 	if err != nil {
-		log.Fatalf("Error encountered. %v", err)
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	if cmdline.InputPath == file.Std && cmdline.Changes == file.Std {
+		log.Fatalf("Invalid arguments. The input file and the changes file cannot both be the standard input.")
+	}
+
+	inputReader, err := getInputReader()
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	ingester := data.NewIngestor(inputReader, file.NewClient(cmdline.Changes), file.NewClient(cmdline.OutputPath))
+	ingester.SetApplyMode(applyMode)
+	ingester.SetSortMode(sortMode)
+	ingester.SetReferencePolicy(refPolicy)
+	ingester.SetIngestMode(ingestMode)
+	ingester.SetChangesFormat(changesFormat)
+	if len(cmdline.UndoPath) != 0 {
+		ingester.SetUndoWriter(file.NewClient(cmdline.UndoPath))
+	}
+	ingester.SetStreaming(cmdline.Stream)
+
+	report, err := execute(ingester)
+
+	if report != nil && len(cmdline.ReportPath) != 0 {
+		reportErr := writeReport(report)
+		if reportErr != nil {
+			log.Printf("Cannot write report file. %v", reportErr)
+		}
+	}
+
+	if err != nil {
+		exitWithError(err)
 	}
 
 	log.Printf("The output file %v was successfully created.", cmdline.OutputPath)
 }
 
-func getInputReader() data.Reader {
+// Execute the ingester, with the on-disk store of the mixtape when there is one. The store is
+// closed before the error is returned, so it is closed before the program exits.
+func execute(ingester *data.Ingester) (*data.Report, error) {
+	if len(cmdline.StorePath) != 0 {
+		store, err := kv.Create(cmdline.StorePath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot create store file. %w", err)
+		}
+		defer store.Close()
+		ingester.SetStorage(store)
+	}
+
+	return ingester.Execute()
+}
+
+func getInputReader() (data.Reader, error) {
 	if len(cmdline.InputPath) != 0 {
-		return file.NewClient(cmdline.InputPath)
-	} else {
-		return http.NewClient((cmdline.InputUrl))
+		return file.NewClient(cmdline.InputPath), nil
 	}
+
+	options, err := getHttpOptions()
+	if err != nil {
+		return nil, err
+	}
+	return http.NewClient(cmdline.InputUrl, options...), nil
+}
+
+// The options of the input file URL client. The errors never include a token or a password.
+func getHttpOptions() ([]http.Option, error) {
+	retry := http.DefaultRetryPolicy
+	retry.MaxRetries = cmdline.Retries
+	options := []http.Option{http.WithRetryPolicy(retry)}
+
+	if len(cmdline.CacheDir) != 0 {
+		options = append(options, http.WithCache(cmdline.CacheDir))
+	}
+
+	for _, header := range cmdline.Headers {
+		name, value, err := http.ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, http.WithHeader(name, value))
+	}
+
+	if len(cmdline.TokenEnv) != 0 && len(cmdline.TokenFile) != 0 {
+		return nil, resources.NewError(resources.CodeInvalidValue, "The -token-env and -token-file arguments cannot be used together.")
+	}
+	if len(cmdline.TokenEnv) != 0 {
+		token, err := http.TokenFromEnv(cmdline.TokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, http.WithBearerToken(token))
+	}
+	if len(cmdline.TokenFile) != 0 {
+		token, err := http.TokenFromFile(cmdline.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, http.WithBearerToken(token))
+	}
+
+	if len(cmdline.BasicUser) != 0 {
+		password, err := http.TokenFromEnv(cmdline.BasicEnv)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, http.WithBasicAuth(cmdline.BasicUser, password))
+	}
+
+	if len(cmdline.CAFile) != 0 {
+		pool, err := http.LoadRootCAs(cmdline.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, http.WithRootCAs(pool))
+	}
+
+	if len(cmdline.CertFile) != 0 || len(cmdline.KeyFile) != 0 {
+		certificate, err := tls.LoadX509KeyPair(cmdline.CertFile, cmdline.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load the client certificate. %w", err)
+		}
+		options = append(options, http.WithClientCertificate(certificate))
+	}
+
+	return options, nil
+}
+
+// Print the error and exit 1. In text form the error is logged, in json form the error and
+// its schema violations are written to stderr.
+func exitWithError(err error) {
+	if cmdline.ErrorForm == "json" {
+		errorJSON, _ := json.MarshalIndent(struct {
+			Error      string                 `json:"error"`
+			Violations []validation.Violation `json:"violations,omitempty"`
+		}{err.Error(), validation.Violations(err)}, "", "  ")
+		fmt.Fprintln(os.Stderr, string(errorJSON))
+		os.Exit(1)
+	}
+
+	log.Fatalf("Error encountered. %v", err)
+}
+
+func writeReport(report *data.Report) error {
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return file.NewClient(cmdline.ReportPath).Write(reportJSON)
 }
 
 // Initialize the command line arguments. Print usage highspot -h.
 func init() {
 	flag.StringVar(&cmdline.InputUrl, "u", "https://gist.githubusercontent.com/jmodjeska/0679cf6cd670f76f07f1874ce00daaeb/raw/a4ac53fa86452ac26d706df2e851fb7d02697b4b/mixtape-data.json", "The input file URL.")
-	flag.StringVar(&cmdline.InputPath, "p", "", "The input file path.")
-	flag.StringVar(&cmdline.OutputPath, "o", "output.json", "The output file path.")
-	flag.StringVar(&cmdline.Changes, "c", "changes.json", "The changes file.")
+	flag.StringVar(&cmdline.InputPath, "p", "", "The input file path, - for the standard input. A gzip, zstd or bzip2 file is decompressed.")
+	flag.StringVar(&cmdline.OutputPath, "o", "output.json", "The output file path, - for the standard output. A .gz, .zst or .bz2 file is compressed.")
+	flag.StringVar(&cmdline.IngestMode, "i", "lenient", "The input mode for invalid playlists, strict, lenient or repair.")
+	flag.StringVar(&cmdline.ReportPath, "r", "", "The report file path.")
+	flag.StringVar(&cmdline.UndoPath, "undo", "", "The undo file path, the changes that turn the output file back into the input file.")
+	flag.StringVar(&cmdline.SortMode, "s", "insertion", "The output order, insertion, id or user.")
+	flag.StringVar(&cmdline.RefPolicy, "refs", "reject", "The policy for the playlists of a removed user or song, reject, cascade or strip.")
+	flag.StringVar(&cmdline.Changes, "c", "changes.json", "The changes file, - for the standard input.")
+	flag.StringVar(&cmdline.Format, "cf", "detect", "The changes file format, json-patch, merge-patch, or detect from the document.")
+	flag.StringVar(&cmdline.ApplyMode, "a", "transactional", "The apply mode, transactional or best-effort.")
+	flag.BoolVar(&cmdline.Stream, "stream", false, "Stream the input file, so the input document is not held in memory. The mixtape is kept in memory unless -store is given.")
+	flag.StringVar(&cmdline.StorePath, "store", "", "The on-disk store file of the mixtape, for a mixtape that does not fit in memory. The file is replaced.")
+	flag.StringVar(&cmdline.ErrorForm, "e", "text", "The error output form, text or json.")
 	flag.BoolVar(&cmdline.Help, "h", false, "Print the help text.")
+	addHttpFlags(flag.CommandLine)
 	flag.Usage = printUsage
 }
 
+// Add the arguments of the input file URL client
+func addHttpFlags(flags *flag.FlagSet) {
+	flags.Var(&cmdline.Headers, "header", "A header of the input file URL request, Name: value. Can be repeated.")
+	flags.StringVar(&cmdline.TokenEnv, "token-env", "", "The environment variable with the bearer token of the input file URL.")
+	flags.StringVar(&cmdline.TokenFile, "token-file", "", "The file with the bearer token of the input file URL.")
+	flags.StringVar(&cmdline.BasicUser, "basic-user", "", "The basic auth user name of the input file URL.")
+	flags.StringVar(&cmdline.BasicEnv, "basic-password-env", "HIGHSPOT_PASSWORD", "The environment variable with the basic auth password.")
+	flags.StringVar(&cmdline.CAFile, "ca-file", "", "The PEM file with the CA certificates of the input file URL server.")
+	flags.StringVar(&cmdline.CertFile, "cert-file", "", "The PEM file with the client certificate for the input file URL.")
+	flags.StringVar(&cmdline.KeyFile, "key-file", "", "The PEM file with the client certificate key.")
+	flags.StringVar(&cmdline.CacheDir, "cache", "", "The directory of the input file URL cache, the cache is not used when empty.")
+	flags.IntVar(&cmdline.Retries, "retries", http.DefaultRetryPolicy.MaxRetries, "The number of retries of the input file URL for a 5xx or 429 response.")
+}
+
 func printUsage() {
 	fmt.Print("The Highspot take-home coding exercise.\n\n")
-	fmt.Print("Usage: highspot [arguments]\n\n")
+	fmt.Print("Usage: highspot [arguments]\n")
+	fmt.Print("       highspot sync [arguments]\n")
+	fmt.Print("       highspot feed-server [arguments]\n")
+	fmt.Print("       highspot db import|apply|export [arguments]\n")
+	fmt.Print("       highspot serve [arguments]\n")
+	fmt.Print("       highspot diff [arguments]\n\n")
 	fmt.Print("The arguments are:\n\n")
 	flag.PrintDefaults()
 }
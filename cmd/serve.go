@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"highspot/data"
+	"highspot/data/file"
+	"highspot/resources"
+	"highspot/server"
+	"log"
+	"net/http"
+)
+
+// The serve subcommand serves the input file as REST resources, each change is written to the
+// output file
+func runServe(args []string) {
+	var address string
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&address, "addr", "localhost:8080", "The address the server listens on.")
+	flags.StringVar(&cmdline.InputPath, "p", "mixtape.json", "The input file path.")
+	flags.StringVar(&cmdline.OutputPath, "o", "output.json", "The output file path, written on each change. Can be the input file path.")
+	flags.StringVar(&cmdline.IngestMode, "i", "lenient", "The input mode for invalid playlists, strict, lenient or repair.")
+	flags.StringVar(&cmdline.ApplyMode, "a", "transactional", "The apply mode of a JSON patch, transactional or best-effort.")
+	flags.StringVar(&cmdline.SortMode, "s", "insertion", "The output order, insertion, id or user.")
+	flags.StringVar(&cmdline.RefPolicy, "refs", "reject", "The policy for the playlists of a removed user or song, reject, cascade or strip.")
+	flags.Usage = func() {
+		fmt.Print("Serve the input file as REST resources.\n\n")
+		fmt.Print("Usage: highspot serve [arguments]\n\n")
+		fmt.Print("The arguments are:\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ingestMode, err := resources.ParseIngestMode(cmdline.IngestMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	applyMode, err := data.ParseApplyMode(cmdline.ApplyMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	sortMode, err := resources.ParseSortMode(cmdline.SortMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	refPolicy, err := resources.ParseReferencePolicy(cmdline.RefPolicy)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	input, err := file.NewClient(cmdline.InputPath).Read()
+	if err != nil {
+		log.Fatalf("Cannot read input file. %v", err)
+	}
+
+	mixtape, err := data.DecodeMixTape(input, ingestMode)
+	if err != nil {
+		exitWithError(err)
+	}
+	for _, issue := range mixtape.IngestionIssues() {
+		log.Printf("Playlist ID %v %v. %v", issue.PlayListID, issue.Action, issue.Message)
+	}
+	mixtape.SetSortMode(sortMode)
+	mixtape.SetReferencePolicy(refPolicy)
+
+	apiServer := server.NewAPIServer(mixtape, file.NewClient(cmdline.OutputPath))
+	apiServer.SetApplyMode(applyMode)
+
+	log.Printf("Serving %v on %v, the changes are written to %v.", cmdline.InputPath, address, cmdline.OutputPath)
+	log.Fatal(http.ListenAndServe(address, apiServer))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"highspot/data"
+	"highspot/data/file"
+	"highspot/data/http"
+	"highspot/resources"
+	"log"
+	"os"
+)
+
+// The sync subcommand synchronizes the output file with the paginated feed. The output file,
+// when it exists, is the mixtape that is synchronized, and the cursors file has the last
+// stream id of each entity, so each run only applies the new entities of the feed.
+func runSync(args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	flags.StringVar(&cmdline.InputUrl, "u", "", "The feed base URL, with the users, songs and playlists endpoints.")
+	flags.StringVar(&cmdline.OutputPath, "o", "output.json", "The output file path, the mixtape that is synchronized.")
+	flags.StringVar(&cmdline.Cursors, "cursors", "cursors.json", "The cursors file path.")
+	flags.IntVar(&cmdline.Limit, "limit", http.DefaultFeedLimit, "The number of entities in a batch.")
+	flags.StringVar(&cmdline.SortMode, "s", "insertion", "The output order, insertion, id or user.")
+	flags.StringVar(&cmdline.RefPolicy, "refs", "reject", "The policy for the playlists of a removed user or song, reject, cascade or strip.")
+	flags.StringVar(&cmdline.ErrorForm, "e", "text", "The error output form, text or json.")
+	addHttpFlags(flags)
+	flags.Usage = func() {
+		fmt.Print("Synchronize the output file with the paginated feed.\n\n")
+		fmt.Print("Usage: highspot sync [arguments]\n\n")
+		fmt.Print("The arguments are:\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(cmdline.InputUrl) == 0 {
+		log.Fatalf("Invalid arguments. The feed base URL is required.")
+	}
+
+	sortMode, err := resources.ParseSortMode(cmdline.SortMode)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	refPolicy, err := resources.ParseReferencePolicy(cmdline.RefPolicy)
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	options, err := getHttpOptions()
+	if err != nil {
+		log.Fatalf("Invalid arguments. %v", err)
+	}
+
+	mixtape, err := readSyncedMixTape()
+	if err != nil {
+		exitWithError(err)
+	}
+	mixtape.SetSortMode(sortMode)
+	mixtape.SetReferencePolicy(refPolicy)
+
+	cursors, err := http.LoadFeedCursors(cmdline.Cursors)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	counts, err := http.NewFeedClient(cmdline.InputUrl, cmdline.Limit, options...).Sync(mixtape, cursors)
+	if err != nil {
+		exitWithError(fmt.Errorf("Sync failed. %w", err))
+	}
+
+	//
+	// The output file is written before the cursors. When the cursors cannot be written the
+	// next run applies the same entities again, which leaves the mixtape unchanged.
+	//
+
+	err = data.WriteOutput(file.NewClient(cmdline.OutputPath), mixtape)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	err = cursors.Save(cmdline.Cursors)
+	if err != nil {
+		exitWithError(fmt.Errorf("Cannot write cursors file. %w", err))
+	}
+
+	log.Printf("The output file %v was synchronized, %v users, %v songs and %v playlists applied.",
+		cmdline.OutputPath, counts["users"], counts["songs"], counts["playlists"])
+}
+
+// Read the mixtape of the output file, an empty mixtape when the file does not exist
+func readSyncedMixTape() (*resources.MixTape, error) {
+	output, err := file.NewClient(cmdline.OutputPath).Read()
+	if os.IsNotExist(err) {
+		return resources.NewMixTape(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read output file. %w", err)
+	}
+	return data.DecodeMixTape(output, resources.IngestStrict)
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"highspot/data/validation"
+	"highspot/resources"
+	"io"
+)
+
+// Validate and unmarshal a mixtape JSON document, invalid playlists are handled by the ingest mode
+func DecodeMixTape(data []byte, mode resources.IngestMode) (*resources.MixTape, error) {
+	err := validation.Validate(validation.InputSchema, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input file. %w", err)
+	}
+
+	var mixtape resources.MixTape
+	mixtape.SetIngestMode(mode)
+	err = json.Unmarshal(data, &mixtape)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid input file. %w", err)
+	}
+
+	return &mixtape, nil
+}
+
+// Validate and unmarshal a changes JSON document
+func DecodeChanges(data []byte) ([]resources.Change, error) {
+	err := validation.Validate(validation.PatchSchema, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []resources.Change
+	err = json.Unmarshal(data, &changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// Marshal changes to an indented JSON document, which must be a valid changes file
+func EncodeChanges(changes []resources.Change) ([]byte, error) {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot write changes file. %w", err)
+	}
+
+	err = validation.Validate(validation.PatchSchema, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid changes file. %w", err)
+	}
+
+	var prettyJSON bytes.Buffer
+	err = json.Indent(&prettyJSON, data, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot write changes file. %w", err)
+	}
+
+	return prettyJSON.Bytes(), nil
+}
+
+// Marshal a mixtape to an indented JSON document, which must be a valid input file
+func EncodeMixTape(mixtape *resources.MixTape) ([]byte, error) {
+	data, err := json.Marshal(mixtape)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot write output file. %w", err)
+	}
+
+	//
+	// The output must be a valid input file
+	//
+	err = validation.Validate(validation.InputSchema, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid output file. %w", err)
+	}
+
+	var prettyJSON bytes.Buffer
+	err = json.Indent(&prettyJSON, data, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot write output file. %w", err)
+	}
+
+	return prettyJSON.Bytes(), nil
+}
+
+// Write a mixtape to the output writer. A stream writer is written one element at a time, and
+// it is not replaced when the mixtape cannot be written, otherwise the mixtape is encoded by
+// EncodeMixTape.
+func WriteOutput(writer Writer, mixtape *resources.MixTape) error {
+	streamWriter, ok := writer.(StreamWriter)
+	if !ok {
+		data, err := EncodeMixTape(mixtape)
+		if err != nil {
+			return err
+		}
+
+		err = writer.Write(data)
+		if err != nil {
+			return fmt.Errorf("Cannot write output file. %w", err)
+		}
+		return nil
+	}
+
+	var outputErr error
+	err := streamWriter.WriteStream(func(w io.Writer) error {
+		outputErr = WriteMixTape(w, mixtape)
+		return outputErr
+	})
+	if outputErr != nil {
+		return outputErr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write output file. %w", err)
+	}
+	return nil
+}
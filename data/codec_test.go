@@ -0,0 +1,76 @@
+package data
+
+import (
+	"bytes"
+	"highspot/data/validation"
+	"highspot/resources"
+	"io"
+	"testing"
+)
+
+// A stream writer that keeps the written document in memory, only when write succeeds
+type memoryStreamWriter struct {
+	memoryWriter
+}
+
+func (w *memoryStreamWriter) WriteStream(write func(w io.Writer) error) error {
+	var buffer bytes.Buffer
+	err := write(&buffer)
+	if err != nil {
+		return err
+	}
+	return w.Write(buffer.Bytes())
+}
+
+// The output of WriteOutput with a writer and with a stream writer, nil when it is not written
+func writeOutputs(mixtape *resources.MixTape) (map[string][]byte, map[string]error) {
+	writer := &memoryWriter{}
+	streamWriter := &memoryStreamWriter{}
+
+	errs := map[string]error{
+		"writer":        WriteOutput(writer, mixtape),
+		"stream writer": WriteOutput(streamWriter, mixtape),
+	}
+	outputs := map[string][]byte{
+		"writer":        writer.data,
+		"stream writer": streamWriter.data,
+	}
+	return outputs, errs
+}
+
+func TestWriteOutputValidatesTheOutput(t *testing.T) {
+	mixtape := newPatchTestMixTape(t)
+
+	outputs, errs := writeOutputs(mixtape)
+	for name, output := range outputs {
+		if errs[name] != nil {
+			t.Fatalf("%v: %v", name, errs[name])
+		}
+		if _, err := DecodeMixTape(output, resources.IngestStrict); err != nil {
+			t.Errorf("%v: expected a valid input file, got %v", name, err)
+		}
+	}
+	if !bytes.Equal(outputs["writer"], outputs["stream writer"]) {
+		t.Errorf("expected the same output from both writers, got\n%s\nand\n%s", outputs["writer"], outputs["stream writer"])
+	}
+
+	//
+	// A user name that the changes cannot produce, but the storage accepts, is not written
+	//
+
+	err := mixtape.ReplaceUser(&resources.User{ID: "2", Name: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, errs = writeOutputs(mixtape)
+	for name, output := range outputs {
+		violations := validation.Violations(errs[name])
+		if len(violations) != 1 || violations[0].Pointer != "/users/1/name" {
+			t.Errorf("%v: expected the violation of /users/1/name, got %v", name, errs[name])
+		}
+		if output != nil {
+			t.Errorf("%v: expected no output, got %s", name, output)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"highspot/resources"
+)
+
+//
+// The diff of two mixtapes is a changes file that turns the before mixtape into the after
+// mixtape. The users, songs and playlists are compared by ID, so an entity that is in both
+// mixtapes is changed rather than removed and added. A new entity is added at the end of its
+// collection, in the order of the after mixtape.
+//
+// The insertion order of the collections is compared too. The longest start of the after
+// order that is already in order in the before mixtape is kept, and the later entities are
+// added, or moved to the end of their collection, in the after order, so the changes produce
+// the after mixtape in every output order.
+//
+// The changes are in the order of the merge patch changes: users and songs are added or
+// changed before the playlists that use them, and removed after the playlists that used them
+// are removed or changed, so each change keeps the mixtape valid with any reference policy.
+//
+
+// A user, song or playlist of a mixtape
+type entityKey struct {
+	collection string
+	id         string
+}
+
+var collections = []string{"users", "songs", "playlists"}
+
+// The users, songs and playlists of a mixtape, or of a part of a mixtape
+type snapshot struct {
+	users     []*resources.User
+	songs     []*resources.Song
+	playlists []*resources.PlayList
+}
+
+// The entities of the mixtape, in insertion order
+func snapshotMixTape(mixtape *resources.MixTape) (*snapshot, error) {
+	view := insertionOrderView(mixtape)
+	users, err := view.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	songs, err := view.GetSongs()
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := view.GetPlayLists()
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{users, songs, playlists}, nil
+}
+
+// The IDs of each collection of the snapshot, in its order
+func (s *snapshot) order() map[string][]string {
+	order := map[string][]string{}
+	for _, user := range s.users {
+		order["users"] = append(order["users"], user.ID)
+	}
+	for _, song := range s.songs {
+		order["songs"] = append(order["songs"], song.ID)
+	}
+	for _, playlist := range s.playlists {
+		order["playlists"] = append(order["playlists"], playlist.ID)
+	}
+	return order
+}
+
+// Compare the mixtapes by ID and return the changes that turn the before mixtape into the
+// after mixtape
+func Diff(before, after *resources.MixTape) ([]resources.Change, error) {
+	beforeSnapshot, err := snapshotMixTape(before)
+	if err != nil {
+		return nil, err
+	}
+	afterSnapshot, err := snapshotMixTape(after)
+	if err != nil {
+		return nil, err
+	}
+	moved := movedEntities(beforeSnapshot.order(), afterSnapshot.order())
+	return diffSnapshots(beforeSnapshot, afterSnapshot, moved)
+}
+
+// The changes of the entities of the after snapshot are in its order, and an entity that is
+// moved is moved to the end of its collection after its changes
+func diffSnapshots(before, after *snapshot, moved map[entityKey]bool) ([]resources.Change, error) {
+	changes := []resources.Change{}
+
+	users := map[string]*resources.User{}
+	for _, user := range before.users {
+		users[user.ID] = user
+	}
+	for _, user := range after.users {
+		changes = append(changes, diffUser(users[user.ID], user)...)
+		if moved[entityKey{"users", user.ID}] {
+			changes = append(changes, moveChange("users", user.ID))
+		}
+	}
+
+	songs := map[string]*resources.Song{}
+	for _, song := range before.songs {
+		songs[song.ID] = song
+	}
+	for _, song := range after.songs {
+		changes = append(changes, diffSong(songs[song.ID], song)...)
+		if moved[entityKey{"songs", song.ID}] {
+			changes = append(changes, moveChange("songs", song.ID))
+		}
+	}
+
+	playlists := map[string]*resources.PlayList{}
+	for _, playlist := range after.playlists {
+		playlists[playlist.ID] = playlist
+	}
+	for _, playlist := range before.playlists {
+		if playlists[playlist.ID] == nil {
+			changes = append(changes, removeChange("playlists", playlist.ID))
+		}
+	}
+
+	playlists = map[string]*resources.PlayList{}
+	for _, playlist := range before.playlists {
+		playlists[playlist.ID] = playlist
+	}
+	for _, playlist := range after.playlists {
+		playlistChanges, err := diffPlayList(playlists[playlist.ID], playlist)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, playlistChanges...)
+		if moved[entityKey{"playlists", playlist.ID}] {
+			changes = append(changes, moveChange("playlists", playlist.ID))
+		}
+	}
+
+	songs = map[string]*resources.Song{}
+	for _, song := range after.songs {
+		songs[song.ID] = song
+	}
+	for _, song := range before.songs {
+		if songs[song.ID] == nil {
+			changes = append(changes, removeChange("songs", song.ID))
+		}
+	}
+
+	users = map[string]*resources.User{}
+	for _, user := range after.users {
+		users[user.ID] = user
+	}
+	for _, user := range before.users {
+		if users[user.ID] == nil {
+			changes = append(changes, removeChange("users", user.ID))
+		}
+	}
+
+	return changes, nil
+}
+
+// The entities of the current order that are moved to the end of their collection to get the
+// target order. The longest start of the target order that is in order in the current order
+// is kept, the later entities of the target order are moved, or added when they are new.
+func movedEntities(current, target map[string][]string) map[entityKey]bool {
+	moved := map[entityKey]bool{}
+	for _, collection := range collections {
+		exists := map[string]bool{}
+		for _, id := range current[collection] {
+			exists[id] = true
+		}
+
+		kept := keptOrder(target[collection], current[collection])
+		for _, id := range target[collection][kept:] {
+			if exists[id] {
+				moved[entityKey{collection, id}] = true
+			}
+		}
+	}
+	return moved
+}
+
+// The number of IDs at the start of the target order that are in the same order in the
+// current order
+func keptOrder(target, current []string) int {
+	positions := map[string]int{}
+	for position, id := range current {
+		positions[id] = position
+	}
+
+	last := -1
+	for index, id := range target {
+		position, ok := positions[id]
+		if !ok || position < last {
+			return index
+		}
+		last = position
+	}
+	return len(target)
+}
+
+// The IDs of each collection of the mixtape in insertion order
+func insertionOrder(mixtape *resources.MixTape) (map[string][]string, error) {
+	view := insertionOrderView(mixtape)
+	order := map[string][]string{}
+
+	err := view.ForEachUser(func(user *resources.User) error {
+		order["users"] = append(order["users"], user.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = view.ForEachSong(func(song *resources.Song) error {
+		order["songs"] = append(order["songs"], song.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = view.ForEachPlayList(func(playlist *resources.PlayList) error {
+		order["playlists"] = append(order["playlists"], playlist.ID)
+		return nil
+	})
+	return order, err
+}
+
+// A transaction of the mixtape in insertion order, which is only read, so the sort mode of
+// the mixtape is unchanged
+func insertionOrderView(mixtape *resources.MixTape) *resources.MixTape {
+	view := mixtape.Begin()
+	view.SetSortMode(resources.SortByInsertion)
+	return view
+}
+
+// Check that the changes turn the before mixtape into the after mixtape. The changes are
+// applied to a transaction of the before mixtape, which is discarded.
+func VerifyDiff(before, after *resources.MixTape, changes []resources.Change) error {
+	tx := before.Begin()
+	_, err := ApplyChanges(tx, changes, Transactional)
+	if err != nil {
+		return err
+	}
+
+	remaining, err := Diff(tx, after)
+	if err != nil {
+		return err
+	}
+	if len(remaining) != 0 {
+		return fmt.Errorf("The changes do not produce the after mixtape, %v changes remain.", len(remaining))
+	}
+	return nil
+}
+
+// A new user is added, a changed user has its name replaced
+func diffUser(before, after *resources.User) []resources.Change {
+	path := entityPath("users", after.ID)
+	if before == nil {
+		return []resources.Change{{Op: "add", Path: path, Value: after}}
+	}
+	if before.Name != after.Name {
+		return []resources.Change{{Op: "replace", Path: path + "/name", Value: after.Name}}
+	}
+	return nil
+}
+
+// A new song is added, a changed song has its changed field replaced, or the song is replaced
+// when both fields changed
+func diffSong(before, after *resources.Song) []resources.Change {
+	path := entityPath("songs", after.ID)
+	switch {
+	case before == nil:
+		return []resources.Change{{Op: "add", Path: path, Value: after}}
+	case before.Artist != after.Artist && before.Title != after.Title:
+		return []resources.Change{{Op: "replace", Path: path, Value: after}}
+	case before.Artist != after.Artist:
+		return []resources.Change{{Op: "replace", Path: path + "/artist", Value: after.Artist}}
+	case before.Title != after.Title:
+		return []resources.Change{{Op: "replace", Path: path + "/title", Value: after.Title}}
+	}
+	return nil
+}
+
+// A new playlist is added, a changed playlist has its user or its songs changed, or the
+// playlist is replaced when both changed
+func diffPlayList(before, after *resources.PlayList) ([]resources.Change, error) {
+	path := entityPath("playlists", after.ID)
+	if before == nil {
+		return []resources.Change{{Op: "add", Path: path, Value: after}}, nil
+	}
+
+	songsChanged := !equalSongIDs(before.SongIDs, after.SongIDs)
+	switch {
+	case before.UserID != after.UserID && songsChanged:
+		return []resources.Change{{Op: "replace", Path: path, Value: after}}, nil
+	case before.UserID != after.UserID:
+		return []resources.Change{{Op: "replace", Path: path + "/user_id", Value: after.UserID}}, nil
+	case songsChanged:
+		return diffSongIDs(path+"/song_ids", before.SongIDs, after.SongIDs)
+	}
+	return nil, nil
+}
+
+// The songs of a playlist are changed by position, the songs that are not in the longest
+// common subsequence of the before and after songs are removed from the last position to the
+// first, and the new songs are then inserted from the first position to the last. The songs
+// are unique in a playlist, so a song that moved is removed before it is inserted, and the
+// playlist keeps at least the common songs. The songs are replaced as a whole when there are
+// no common songs, or when the replace is the smaller change.
+func diffSongIDs(path string, before, after []string) ([]resources.Change, error) {
+	replace := []resources.Change{{Op: "replace", Path: path, Value: after}}
+
+	common := commonSongIDs(before, after)
+	if len(common) == 0 {
+		return replace, nil
+	}
+
+	changes := []resources.Change{}
+	kept := map[int]bool{}
+	for _, pair := range common {
+		kept[pair[0]] = true
+	}
+	for index := len(before) - 1; index >= 0; index-- {
+		if !kept[index] {
+			changes = append(changes, resources.Change{Op: "remove", Path: fmt.Sprintf("%v/%v", path, index)})
+		}
+	}
+
+	kept = map[int]bool{}
+	for _, pair := range common {
+		kept[pair[1]] = true
+	}
+	for index, songID := range after {
+		if !kept[index] {
+			changes = append(changes, resources.Change{Op: "add", Path: fmt.Sprintf("%v/%v", path, index), Value: songID})
+		}
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	replaceJSON, err := json.Marshal(replace)
+	if err != nil {
+		return nil, err
+	}
+	if len(replaceJSON) < len(changesJSON) {
+		return replace, nil
+	}
+	return changes, nil
+}
+
+// The longest common subsequence of the songs, as pairs of before and after positions
+func commonSongIDs(before, after []string) [][2]int {
+	// lengths[i][j] is the length of the longest common subsequence of before[i:] and after[j:]
+	lengths := make([][]int, len(before)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	common := [][2]int{}
+	for i, j := 0, 0; i < len(before) && j < len(after); {
+		switch {
+		case before[i] == after[j]:
+			common = append(common, [2]int{i, j})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return common
+}
+
+func equalSongIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func entityPath(collection, id string) string {
+	return fmt.Sprintf("/%v/%v", collection, escapePointerToken(id))
+}
+
+func removeChange(collection, id string) resources.Change {
+	return resources.Change{Op: "remove", Path: entityPath(collection, id)}
+}
+
+func moveChange(collection, id string) resources.Change {
+	return resources.Change{Op: "move", From: entityPath(collection, id), Path: entityPath(collection, "-")}
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"fmt"
+	"highspot/resources"
+	"math/rand"
+	"testing"
+)
+
+// The users, songs and playlists of a generated mixtape
+type mixtapeFixture struct {
+	users     []resources.User
+	songs     []resources.Song
+	playlists []resources.PlayList
+}
+
+// Generate a valid mixtape, the IDs are from the offset
+func randomFixture(r *rand.Rand, offset int) *mixtapeFixture {
+	f := &mixtapeFixture{}
+	for i := 0; i < 1+r.Intn(6); i++ {
+		f.users = append(f.users, resources.User{ID: fmt.Sprint(offset + i), Name: fmt.Sprint("User ", r.Intn(3))})
+	}
+	for i := 0; i < 1+r.Intn(12); i++ {
+		f.songs = append(f.songs, resources.Song{ID: fmt.Sprint(offset + i), Artist: fmt.Sprint("Artist ", r.Intn(2)), Title: fmt.Sprint("Title ", r.Intn(2))})
+	}
+	for i := 0; i < r.Intn(8); i++ {
+		f.playlists = append(f.playlists, resources.PlayList{
+			ID:      fmt.Sprint(offset + i),
+			UserID:  f.users[r.Intn(len(f.users))].ID,
+			SongIDs: randomSongIDs(r, f.songs),
+		})
+	}
+	return f
+}
+
+// Distinct songs in a random order
+func randomSongIDs(r *rand.Rand, songs []resources.Song) []string {
+	songIDs := []string{}
+	for _, index := range r.Perm(len(songs))[:1+r.Intn(len(songs))] {
+		songIDs = append(songIDs, songs[index].ID)
+	}
+	return songIDs
+}
+
+// Change a mixtape into another valid mixtape with shared IDs. Users and songs are renamed,
+// removed and added, the songs of the playlists are reordered, changed and overlap, and the
+// collections are reordered.
+func mutateFixture(r *rand.Rand, a *mixtapeFixture) *mixtapeFixture {
+	b := &mixtapeFixture{}
+
+	removedUsers := map[string]bool{}
+	for _, user := range a.users {
+		switch r.Intn(5) {
+		case 0:
+			removedUsers[user.ID] = true
+			continue
+		case 1:
+			user.Name += " renamed"
+		}
+		b.users = append(b.users, user)
+	}
+	for i := 0; i < r.Intn(3); i++ {
+		b.users = append(b.users, resources.User{ID: fmt.Sprint(1000 + i), Name: "New"})
+	}
+	if len(b.users) == 0 {
+		b.users = append(b.users, resources.User{ID: "999", Name: "Only"})
+	}
+
+	removedSongs := map[string]bool{}
+	for _, song := range a.songs {
+		switch r.Intn(6) {
+		case 0:
+			removedSongs[song.ID] = true
+			continue
+		case 1:
+			song.Artist += " remixed"
+		case 2:
+			song.Title += " live"
+		case 3:
+			song.Artist, song.Title = song.Title, song.Artist
+		}
+		b.songs = append(b.songs, song)
+	}
+	for i := 0; i < r.Intn(4); i++ {
+		b.songs = append(b.songs, resources.Song{ID: fmt.Sprint(1000 + i), Artist: "New", Title: "New"})
+	}
+	if len(b.songs) == 0 {
+		b.songs = append(b.songs, resources.Song{ID: "999", Artist: "Only", Title: "Only"})
+	}
+
+	for _, playlist := range a.playlists {
+		if r.Intn(6) == 0 {
+			continue
+		}
+
+		songIDs := []string{}
+		for _, songID := range playlist.SongIDs {
+			if !removedSongs[songID] {
+				songIDs = append(songIDs, songID)
+			}
+		}
+		switch r.Intn(5) {
+		case 0:
+			r.Shuffle(len(songIDs), func(i, j int) { songIDs[i], songIDs[j] = songIDs[j], songIDs[i] })
+		case 1:
+			// Overlap the songs with another selection of songs
+			seen := map[string]bool{}
+			overlap := []string{}
+			for _, songID := range append(songIDs[:len(songIDs)/2], randomSongIDs(r, b.songs)...) {
+				if !seen[songID] {
+					seen[songID] = true
+					overlap = append(overlap, songID)
+				}
+			}
+			songIDs = overlap
+		case 2:
+			songIDs = randomSongIDs(r, b.songs)
+		}
+		if len(songIDs) == 0 {
+			songIDs = randomSongIDs(r, b.songs)
+		}
+
+		userID := playlist.UserID
+		if removedUsers[userID] || r.Intn(5) == 0 {
+			userID = b.users[r.Intn(len(b.users))].ID
+		}
+
+		b.playlists = append(b.playlists, resources.PlayList{ID: playlist.ID, UserID: userID, SongIDs: songIDs})
+	}
+	for i := 0; i < r.Intn(3); i++ {
+		b.playlists = append(b.playlists, resources.PlayList{
+			ID:      fmt.Sprint(1000 + i),
+			UserID:  b.users[r.Intn(len(b.users))].ID,
+			SongIDs: randomSongIDs(r, b.songs),
+		})
+	}
+
+	// Reorder the collections, so the new entities are among the kept ones
+	if r.Intn(2) == 0 {
+		r.Shuffle(len(b.users), func(i, j int) { b.users[i], b.users[j] = b.users[j], b.users[i] })
+	}
+	if r.Intn(2) == 0 {
+		r.Shuffle(len(b.songs), func(i, j int) { b.songs[i], b.songs[j] = b.songs[j], b.songs[i] })
+	}
+	if r.Intn(2) == 0 {
+		r.Shuffle(len(b.playlists), func(i, j int) { b.playlists[i], b.playlists[j] = b.playlists[j], b.playlists[i] })
+	}
+
+	return b
+}
+
+func (f *mixtapeFixture) build(t *testing.T) *resources.MixTape {
+	mixtape := resources.NewMixTape()
+	for i := range f.users {
+		user := f.users[i]
+		if err := mixtape.AddUser(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range f.songs {
+		song := f.songs[i]
+		if err := mixtape.AddSong(&song); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range f.playlists {
+		playlist := f.playlists[i]
+		if err := mixtape.AddPlayList(&playlist); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mixtape
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	policies := []resources.ReferencePolicy{resources.RejectReferences, resources.CascadeReferences, resources.StripReferences}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		a := randomFixture(r, 0)
+
+		var b *mixtapeFixture
+		switch i % 4 {
+		case 0:
+			// Disjoint IDs, every entity is removed and added
+			b = randomFixture(r, 100)
+		case 1:
+			// The same IDs with other values
+			b = randomFixture(r, 0)
+		default:
+			b = mutateFixture(r, a)
+		}
+
+		changes, err := Diff(a.build(t), b.build(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//
+		// The changes are written and read as a changes file
+		//
+		changesJSON, err := EncodeChanges(changes)
+		if err != nil {
+			t.Fatalf("pair %v: %v", i, err)
+		}
+		changes, err = DecodeChanges(changesJSON)
+		if err != nil {
+			t.Fatalf("pair %v: %v", i, err)
+		}
+
+		expected := documentInInsertionOrder(t, b.build(t))
+		for _, policy := range policies {
+			mixtape := a.build(t)
+			mixtape.SetReferencePolicy(policy)
+
+			_, err = ApplyChanges(mixtape, changes, Transactional)
+			if err != nil {
+				t.Fatalf("pair %v, policy %v: %v\n%s", i, policy, err, changesJSON)
+			}
+
+			remaining, err := Diff(mixtape, b.build(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(remaining) != 0 {
+				t.Fatalf("pair %v, policy %v: %v changes remain\n%s", i, policy, len(remaining), changesJSON)
+			}
+			if actual := documentInInsertionOrder(t, mixtape); actual != expected {
+				t.Fatalf("pair %v, policy %v: expected\n%v\ngot\n%v", i, policy, expected, actual)
+			}
+		}
+	}
+}
+
+func TestDiffOfEqualMixTapes(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		a := randomFixture(r, 0)
+		changes, err := Diff(a.build(t), a.build(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Fatalf("expected no changes, got %v", changes)
+		}
+	}
+}
+
+func TestDiffSongIDs(t *testing.T) {
+	tests := []struct {
+		before, after []string
+		ops           []string
+	}{
+		// Songs appended, removed and moved are changed by position
+		{[]string{"1", "2", "3", "4", "5"}, []string{"1", "2", "3", "4", "5", "6"}, []string{"add /p/5"}},
+		{[]string{"1", "2", "3", "4", "5"}, []string{"1", "2", "4", "5"}, []string{"remove /p/2"}},
+		{[]string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}, []string{"1", "3", "4", "5", "6", "7", "8", "9", "2"}, []string{"remove /p/1", "add /p/8"}},
+		// Songs without common songs, or with a smaller replace, are replaced
+		{[]string{"1", "2"}, []string{"3", "4"}, []string{"replace /p"}},
+		{[]string{"1", "2", "3"}, []string{"3", "2", "1"}, []string{"replace /p"}},
+	}
+
+	for _, test := range tests {
+		changes, err := diffSongIDs("/p", test.before, test.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := []string{}
+		for _, change := range changes {
+			ops = append(ops, change.Op+" "+change.Path)
+		}
+		if fmt.Sprint(ops) != fmt.Sprint(test.ops) {
+			t.Errorf("%v to %v: expected %v, got %v", test.before, test.after, test.ops, ops)
+		}
+	}
+}
+
+func TestKeptOrder(t *testing.T) {
+	tests := []struct {
+		target, current []string
+		kept            int
+	}{
+		{[]string{"1", "2", "3"}, []string{"1", "2", "3"}, 3},
+		{[]string{"1", "2", "3"}, []string{"1", "3"}, 1},
+		{[]string{"1", "2", "3"}, []string{"2", "3", "1"}, 1},
+		{[]string{"1", "2", "3"}, []string{"2", "3"}, 0},
+		{[]string{"1", "2", "3"}, []string{"1", "9", "3", "2"}, 2},
+		{[]string{}, []string{"1"}, 0},
+	}
+	for _, test := range tests {
+		if kept := keptOrder(test.target, test.current); kept != test.kept {
+			t.Errorf("keptOrder(%v, %v) = %v, expected %v", test.target, test.current, kept, test.kept)
+		}
+	}
+}
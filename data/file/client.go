@@ -1,9 +1,16 @@
 package file
 
 import (
+	"bufio"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
+// The path of the standard input, or output
+const Std = "-"
+
 type Client struct {
 	path string
 }
@@ -15,14 +22,121 @@ func NewClient(path string) *Client {
 	return &client
 }
 
+// Read the file, decompressed
 func (c *Client) Read() ([]byte, error) {
-	data, err := ioutil.ReadFile(c.path)
+	file, err := c.Open()
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
 }
 
+// Open the file to stream it, decompressed. The path - is the standard input.
+func (c *Client) Open() (io.ReadCloser, error) {
+	if c.path == Std {
+		return decompress(ioutil.NopCloser(os.Stdin))
+	}
+
+	file, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(file)
+}
+
+// Replace the file with data
 func (c *Client) Write(data []byte) error {
-	return ioutil.WriteFile(c.path, data, 0644)
+	return c.WriteStream(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Replace the file with the data written by write, compressed by the extension of the file.
+// The data is written to a temporary file in the directory of the file, which is synced and
+// renamed over the file. The file is either replaced as a whole, or left unchanged when write
+// or the file system fails, so a crash never leaves a truncated file. The path - is the
+// standard output, which is written as the data is written.
+func (c *Client) WriteStream(write func(w io.Writer) error) error {
+	if c.path == Std {
+		return writeFile(os.Stdout, uncompressed, write)
+	}
+
+	dir, name := filepath.Split(c.path)
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	temp, err := ioutil.TempFile(dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = writeTemp(temp, compressionOf(c.path), write)
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	err = temp.Close()
+	if err == nil {
+		err = os.Rename(temp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	// The rename is durable once the directory is synced, which some platforms do not support
+	syncDir(dir)
+
+	return nil
+}
+
+func writeTemp(temp *os.File, kind compression, write func(w io.Writer) error) error {
+	err := writeFile(temp, kind, write)
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is created 0600, the file is written 0644 as by ioutil.WriteFile
+	err = temp.Chmod(0644)
+	if err != nil {
+		return err
+	}
+
+	return temp.Sync()
+}
+
+func writeFile(file *os.File, kind compression, write func(w io.Writer) error) error {
+	buffered := bufio.NewWriter(file)
+
+	compressor, err := compress(buffered, kind)
+	if err != nil {
+		return err
+	}
+
+	err = write(compressor)
+	if err != nil {
+		return err
+	}
+
+	err = compressor.Close()
+	if err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
@@ -0,0 +1,101 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The names of the files in the directory
+func fileNames(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteReplacesTheFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.json")
+
+	for _, data := range []string{`{"users": []}`, `{}`} {
+		err := NewClient(path).Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != data {
+			t.Errorf("expected %q, got %q", data, actual)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected the mode 0644, got %v", info.Mode().Perm())
+	}
+
+	// The temporary file is renamed
+	if names := fileNames(t, dir); len(names) != 1 {
+		t.Errorf("expected only the output file, got %v", names)
+	}
+}
+
+func TestWriteStreamKeepsTheFileOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "output.json")
+
+	err := ioutil.WriteFile(path, []byte(`{"users": []}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err = NewClient(path).WriteStream(func(w io.Writer) error {
+		_, err := io.WriteString(w, `{"songs": [`)
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the error of the write, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"users": []}` {
+		t.Errorf("expected the file to be unchanged, got %q", data)
+	}
+	if names := fileNames(t, dir); len(names) != 1 {
+		t.Errorf("expected the temporary file to be removed, got %v", names)
+	}
+}
+
+func TestWriteToAMissingDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	err := NewClient(filepath.Join(dir, "missing", "output.json")).Write([]byte(`{}`))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if names := fileNames(t, dir); len(names) != 0 {
+		t.Errorf("expected no file, got %v", names)
+	}
+}
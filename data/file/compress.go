@@ -0,0 +1,127 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+)
+
+//
+// A file can be compressed with gzip, zstd or bzip2. The compression of a file that is read
+// is detected by its magic bytes, so a compressed file can have any name, and the compression
+// of a file that is written is the compression of its extension.
+//
+
+type compression int
+
+const (
+	uncompressed compression = iota
+	compressedGzip
+	compressedZstd
+	compressedBzip2
+)
+
+var magics = []struct {
+	magic       []byte
+	compression compression
+}{
+	{[]byte{0x1f, 0x8b}, compressedGzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, compressedZstd},
+	{[]byte("BZh"), compressedBzip2},
+}
+
+var extensions = map[string]compression{
+	".gz":  compressedGzip,
+	".zst": compressedZstd,
+	".bz2": compressedBzip2,
+}
+
+// The compression of a file that is written, by its extension
+func compressionOf(path string) compression {
+	return extensions[filepath.Ext(path)]
+}
+
+// A decompressed file, closing it closes the decompressor and the file
+type decompressedFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *decompressedFile) Close() error {
+	var err error
+	for _, closer := range f.closers {
+		closeErr := closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Decompress the file by its magic bytes, an uncompressed file is read as is
+func decompress(file io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(file)
+	header, _ := buffered.Peek(4)
+
+	kind := uncompressed
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			kind = m.compression
+			break
+		}
+	}
+
+	switch kind {
+	case compressedGzip:
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{reader, []io.Closer{reader, file}}, nil
+	case compressedZstd:
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader := decoder.IOReadCloser()
+		return &decompressedFile{reader, []io.Closer{reader, file}}, nil
+	case compressedBzip2:
+		reader, err := bzip2.NewReader(buffered, nil)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{reader, []io.Closer{reader, file}}, nil
+	}
+
+	return &decompressedFile{buffered, []io.Closer{file}}, nil
+}
+
+// The writer of an uncompressed file
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Compress the data written to w, closing the compressor flushes the compressed data to w
+func compress(w io.Writer, kind compression) (io.WriteCloser, error) {
+	switch kind {
+	case compressedGzip:
+		return gzip.NewWriter(w), nil
+	case compressedZstd:
+		return zstd.NewWriter(w)
+	case compressedBzip2:
+		return bzip2.NewWriter(w, nil)
+	}
+	return nopWriteCloser{w}, nil
+}
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDocument = `{"users": [{"id": "1", "name": "Albin Jaye"}], "playlists": [], "songs": []}`
+
+func TestCompressionByExtension(t *testing.T) {
+	tests := []struct {
+		name  string
+		magic []byte
+	}{
+		{"output.json", []byte("{")},
+		{"output.json.gz", []byte{0x1f, 0x8b}},
+		{"output.json.zst", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{"output.json.bz2", []byte("BZh")},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		err := NewClient(path).Write([]byte(testDocument))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, test.magic) {
+			t.Errorf("%v: expected the magic bytes %x, got %x", test.name, test.magic, data[:4])
+		}
+
+		// The compressed file is read by its magic bytes, whatever its name
+		renamed := filepath.Join(dir, "renamed-"+test.name+".json")
+		err = os.Rename(path, renamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err = NewClient(renamed).Read()
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if string(data) != testDocument {
+			t.Errorf("%v: expected the document, got %q", test.name, data)
+		}
+	}
+}
+
+func TestReadUncompressed(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file shorter than the magic bytes, or that starts like one, is read as is
+	for _, document := range []string{"", "{}", "B", "BZ", "[1]"} {
+		path := filepath.Join(dir, "input.json")
+		err := ioutil.WriteFile(path, []byte(document), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := NewClient(path).Read()
+		if err != nil {
+			t.Fatalf("%q: %v", document, err)
+		}
+		if string(data) != document {
+			t.Errorf("expected %q, got %q", document, data)
+		}
+	}
+}
+
+func TestReadCorruptCompressedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.json")
+	err := ioutil.WriteFile(path, []byte{0x1f, 0x8b, 0x00, 0x01}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewClient(path).Read()
+	if err == nil {
+		t.Errorf("expected an error for a corrupt gzip file")
+	}
+}
+
+func TestReadStandardInput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.json.gz")
+	err := NewClient(path).Write([]byte(testDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	data, err := NewClient(Std).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testDocument {
+		t.Errorf("expected the decompressed standard input, got %q", data)
+	}
+}
+
+func TestWriteStandardOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout")
+	stdout, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	saved := os.Stdout
+	os.Stdout = stdout
+	err = NewClient(Std).Write([]byte(testDocument))
+	os.Stdout = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The standard output is not compressed
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testDocument {
+		t.Errorf("expected the document on the standard output, got %q", data)
+	}
+}
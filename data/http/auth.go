@@ -0,0 +1,125 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"highspot/resources"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// A secret is a credential that is never printed, so a client or an option logged by
+// mistake does not leak it
+type secret string
+
+func (s secret) String() string {
+	return "[REDACTED]"
+}
+
+func (s secret) GoString() string {
+	return s.String()
+}
+
+// Add a header to every request, a header can be added more than once
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		c.header.Add(name, value)
+	}
+}
+
+// Authenticate every request with a bearer token
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.bearerToken = secret(token)
+	}
+}
+
+// Authenticate every request with basic auth
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.basicUsername = username
+		c.basicPassword = secret(password)
+	}
+}
+
+// Verify the server certificate with the CA certificates instead of the system pool
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.tlsConfig().RootCAs = pool
+	}
+}
+
+// Present the client certificate to the server (mTLS)
+func WithClientCertificate(certificate tls.Certificate) Option {
+	return func(c *Client) {
+		c.tlsConfig().Certificates = append(c.tlsConfig().Certificates, certificate)
+	}
+}
+
+func (c *Client) tlsConfig() *tls.Config {
+	if c.tls == nil {
+		c.tls = &tls.Config{}
+	}
+	return c.tls
+}
+
+// Set the headers and the credentials of the request
+func (c *Client) authorize(req *http.Request) {
+	for name, values := range c.header {
+		req.Header[name] = append([]string{}, values...)
+	}
+
+	if len(c.bearerToken) != 0 {
+		req.Header.Set("Authorization", "Bearer "+string(c.bearerToken))
+	} else if len(c.basicUsername) != 0 {
+		req.SetBasicAuth(c.basicUsername, string(c.basicPassword))
+	}
+}
+
+// Read a token from the environment variable
+func TokenFromEnv(name string) (string, error) {
+	token := strings.TrimSpace(os.Getenv(name))
+	if len(token) == 0 {
+		return "", fmt.Errorf("The environment variable %v is not set.", name)
+	}
+	return token, nil
+}
+
+// Read a token from the file, the surrounding white space is removed
+func TokenFromFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return "", fmt.Errorf("The token file %v is empty.", path)
+	}
+	return token, nil
+}
+
+// Load the PEM encoded CA certificates of the file
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificate found in the CA file %v.", path)
+	}
+	return pool, nil
+}
+
+// Parse a "Name: value" header
+func ParseHeader(header string) (string, string, error) {
+	parts := strings.SplitN(header, ":", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || len(name) == 0 {
+		return "", "", resources.NewError(resources.CodeInvalidValue, "Invalid header, expected Name: value.")
+	}
+	return name, strings.TrimSpace(parts[1]), nil
+}
@@ -0,0 +1,300 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cache of response bodies on disk, keyed by URL and request headers. The entry of a key is
+// a JSON file with the headers of the response and the name of its body file, so a body is
+// written and read as a stream.
+type cache struct {
+	dir string
+}
+
+// The headers to revalidate a cached response body, and the name of the body file
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+	Body         string    `json:"body_file"`
+}
+
+// Cache the response bodies in the directory. A cached body is used without a request while it
+// is fresh, otherwise it is revalidated with If-None-Match and If-Modified-Since.
+func WithCache(dir string) Option {
+	return func(c *Client) {
+		c.cache = &cache{dir: dir}
+	}
+}
+
+// The key of the response of a request is the hash of its URL and of its headers, so a body
+// fetched with a credential or a header is not served to a request with another one
+func cacheKey(req *http.Request) string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%v\n", req.URL.String())
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			fmt.Fprintf(hash, "%q: %q\n", name, value)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (c *cache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *cache) bodyPath(entry *cacheEntry) string {
+	return filepath.Join(c.dir, entry.Body)
+}
+
+// Read the entry of the key, nil when there is none or it cannot be read
+func (c *cache) read(key string) *cacheEntry {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || len(entry.Body) == 0 {
+		return nil
+	}
+	return &entry
+}
+
+// Load the entry of the key and the URL, nil when there is none, it cannot be read or its body
+// file is missing
+func (c *cache) load(key, url string) *cacheEntry {
+	entry := c.read(key)
+	if entry == nil || entry.URL != url {
+		return nil
+	}
+	if _, err := os.Stat(c.bodyPath(entry)); err != nil {
+		return nil
+	}
+	return entry
+}
+
+// Read the body of the entry
+func (c *cache) readBody(entry *cacheEntry) ([]byte, error) {
+	return ioutil.ReadFile(c.bodyPath(entry))
+}
+
+// Open the body of the entry to stream it
+func (c *cache) openBody(entry *cacheEntry) (io.ReadCloser, error) {
+	return os.Open(c.bodyPath(entry))
+}
+
+// Create a new body file of the key. The caller writes the body, and stores an entry with the
+// name of the file or removes it.
+func (c *cache) createBody(key string) (*os.File, error) {
+	err := os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(c.dir, key+"-*.body")
+}
+
+// Store the entry with the body
+func (c *cache) storeBody(key string, entry *cacheEntry, body []byte) error {
+	file, err := c.createBody(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		entry.Body = filepath.Base(file.Name())
+		err = c.store(key, entry)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// Store the entry, the file is replaced by a rename so a reader never sees a partial entry. The
+// body file of the replaced entry is removed when the entry has another one.
+func (c *cache) store(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	previous := c.read(key)
+
+	tmp, err := ioutil.TempFile(c.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), c.path(key))
+	if err != nil {
+		return err
+	}
+
+	if previous != nil && previous.Body != entry.Body {
+		os.Remove(c.bodyPath(previous))
+	}
+	return nil
+}
+
+// A response body that is written to a new body file of the cache as it is read. The entry is
+// stored once the whole body is read, a body that is closed before its end is not cached.
+type cachingBody struct {
+	body  io.ReadCloser
+	file  *os.File
+	cache *cache
+	key   string
+	entry *cacheEntry
+	url   string
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.file == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		_, writeErr := b.file.Write(p[:n])
+		if writeErr != nil {
+			log.Printf("Cannot cache the response of %v. %v", b.url, writeErr)
+			b.discard()
+			return n, err
+		}
+	}
+
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	b.discard()
+	return b.body.Close()
+}
+
+// Store the entry with the body file
+func (b *cachingBody) finish() {
+	file := b.file
+	b.file = nil
+
+	err := file.Close()
+	if err == nil {
+		b.entry.Body = filepath.Base(file.Name())
+		err = b.cache.store(b.key, b.entry)
+	}
+	if err != nil {
+		log.Printf("Cannot cache the response of %v. %v", b.url, err)
+		os.Remove(file.Name())
+	}
+}
+
+// Remove the body file of a body that is not cached
+func (b *cachingBody) discard() {
+	if b.file == nil {
+		return
+	}
+	b.file.Close()
+	os.Remove(b.file.Name())
+	b.file = nil
+}
+
+func (e *cacheEntry) fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+func (e *cacheEntry) setConditionalHeaders(req *http.Request) {
+	if len(e.ETag) != 0 {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if len(e.LastModified) != 0 {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// Update the entry from the headers of a 200 or 304 response
+func (e *cacheEntry) update(header http.Header) {
+	if etag := header.Get("ETag"); len(etag) != 0 {
+		e.ETag = etag
+	}
+	if lastModified := header.Get("Last-Modified"); len(lastModified) != 0 {
+		e.LastModified = lastModified
+	}
+	e.Expires = expires(header)
+}
+
+// Whether the response can be stored, it is not when it has Cache-Control no-store
+func cacheable(header http.Header) bool {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if strings.TrimSpace(strings.ToLower(directive)) == "no-store" {
+			return false
+		}
+	}
+	return true
+}
+
+// The time until which the response is fresh, from the max-age directive of Cache-Control or
+// from Expires. A response with no-cache, or without either header, must be revalidated.
+func expires(header http.Header) time.Time {
+	now := time.Now()
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if directive == "no-cache" {
+			return now
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds <= 0 {
+				return now
+			}
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	if date, err := http.ParseTime(header.Get("Expires")); err == nil {
+		return date
+	}
+
+	return now
+}
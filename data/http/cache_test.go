@@ -0,0 +1,244 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A server of fresh responses that echo the Authorization and X-Tenant headers
+func newEchoServer(t *testing.T, requests *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "%v|%v", r.Header.Get("Authorization"), r.Header.Get("X-Tenant"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCacheIsKeyedByCredentials(t *testing.T) {
+	requests := 0
+	server := newEchoServer(t, &requests)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		options  []Option
+		body     string
+		requests int
+	}{
+		{"token a", []Option{WithBearerToken("a")}, "Bearer a|", 1},
+		{"token a again", []Option{WithBearerToken("a")}, "Bearer a|", 1},
+		{"token b", []Option{WithBearerToken("b")}, "Bearer b|", 2},
+		{"no credentials", nil, "|", 3},
+		{"basic auth", []Option{WithBasicAuth("user", "password")}, "Basic dXNlcjpwYXNzd29yZA==|", 4},
+		{"basic auth with another password", []Option{WithBasicAuth("user", "other")}, "Basic dXNlcjpvdGhlcg==|", 5},
+		{"header", []Option{WithHeader("X-Tenant", "one")}, "|one", 6},
+		{"another header", []Option{WithHeader("X-Tenant", "two")}, "|two", 7},
+		{"header again", []Option{WithHeader("X-Tenant", "one")}, "|one", 7},
+		{"no credentials again", nil, "|", 7},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithCache(dir)}, test.options...)
+		data, err := NewClient(server.URL+"/mixtape.json", options...).Read()
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if string(data) != test.body {
+			t.Errorf("%v: expected the body %q, got %q", test.name, test.body, data)
+		}
+		if requests != test.requests {
+			t.Errorf("%v: expected %v requests, got %v", test.name, test.requests, requests)
+		}
+	}
+}
+
+// A server of a body with an ETag that must be revalidated, a request with the ETag is a 304
+func newETagServer(t *testing.T, notModified *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			*notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, `{"users": []}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	notModified := 0
+	server := newETagServer(t, &notModified)
+	dir := t.TempDir()
+
+	for i, expected := range []int{0, 1, 2} {
+		data, err := NewClient(server.URL+"/mixtape.json", WithCache(dir)).Read()
+		if err != nil {
+			t.Fatalf("read %v: %v", i, err)
+		}
+		if string(data) != `{"users": []}` {
+			t.Errorf("read %v: expected the cached body, got %q", i, data)
+		}
+		if notModified != expected {
+			t.Errorf("read %v: expected %v revalidations, got %v", i, expected, notModified)
+		}
+	}
+}
+
+func TestNotModifiedWithoutCachedBody(t *testing.T) {
+	notModified := 0
+	server := newETagServer(t, &notModified)
+
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"no cache", nil},
+		{"empty cache", []Option{WithCache(t.TempDir())}},
+	}
+
+	for _, test := range tests {
+		options := append([]Option{WithHeader("If-None-Match", `"v1"`)}, test.options...)
+		client := NewClient(server.URL+"/mixtape.json", options...)
+
+		_, err := client.Read()
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotModified {
+			t.Errorf("%v: expected a 304 StatusError from Read, got %v", test.name, err)
+		}
+
+		_, err = client.Open()
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotModified {
+			t.Errorf("%v: expected a 304 StatusError from Open, got %v", test.name, err)
+		}
+	}
+}
+
+func TestCacheStreaming(t *testing.T) {
+	first, second := strings.Repeat("a", 64*1024), strings.Repeat("b", 64*1024)
+	release := make(chan struct{})
+	notModified := 0
+
+	//
+	// The server sends the second part of the body once the client has read the first part
+	//
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, first)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+			fmt.Fprint(w, second)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL+"/mixtape.json", WithCache(t.TempDir()))
+
+	body, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := make([]byte, len(first))
+	_, err = io.ReadFull(body, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	rest, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if string(start)+string(rest) != first+second {
+		t.Fatalf("expected the body of %v bytes, got %v bytes", len(first+second), len(start)+len(rest))
+	}
+
+	//
+	// The revalidated body is streamed from the cache
+	//
+
+	body, err = client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != first+second {
+		t.Errorf("expected the cached body of %v bytes, got %v bytes", len(first+second), len(data))
+	}
+	if notModified != 1 {
+		t.Errorf("expected 1 revalidation, got %v", notModified)
+	}
+
+	data, err = client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != first+second {
+		t.Errorf("expected the cached body of %v bytes from Read, got %v bytes", len(first+second), len(data))
+	}
+	if notModified != 2 {
+		t.Errorf("expected 2 revalidations, got %v", notModified)
+	}
+}
+
+func TestCacheIncompleteBody(t *testing.T) {
+	notModified := 0
+	server := newETagServer(t, &notModified)
+	dir := t.TempDir()
+	client := NewClient(server.URL+"/mixtape.json", WithCache(dir))
+
+	//
+	// A body that is closed before its end is not cached
+	//
+
+	body, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = body.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no cache files, got %v", files)
+	}
+
+	data, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"users": []}` || notModified != 0 {
+		t.Errorf("expected the body without a revalidation, got %q and %v revalidations", data, notModified)
+	}
+}
@@ -1,8 +1,10 @@
 package http
 
 import (
-	"bytes"
+	"crypto/tls"
+	"io"
 	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"time"
@@ -11,22 +13,48 @@ import (
 type Client struct {
 	httpClient *http.Client
 	url        string
+	retry      RetryPolicy
+	cache      *cache
+	sleep      func(time.Duration)
+
+	header        http.Header
+	bearerToken   secret
+	basicUsername string
+	basicPassword secret
+	tls           *tls.Config
 }
 
-func NewClient(url string) *Client {
+// An option configures the client
+type Option func(*Client)
+
+// The status code, headers and body of a 200 or 304 response
+type response struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func NewClient(url string, options ...Option) *Client {
 	client := Client{
-		httpClient: newHttpClient(),
-		url:        url,
+		url:    url,
+		retry:  DefaultRetryPolicy,
+		sleep:  time.Sleep,
+		header: http.Header{},
+	}
+	for _, option := range options {
+		option(&client)
 	}
+	client.httpClient = newHttpClient(client.tls)
 	return &client
 }
 
-func newHttpClient() *http.Client {
+func newHttpClient(tlsConfig *tls.Config) *http.Client {
 	transport := &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: 3 * time.Second,
 		}).Dial,
 		TLSHandshakeTimeout: 5 * time.Second,
+		TLSClientConfig:     tlsConfig,
 	}
 	return &http.Client{
 		Timeout:   time.Minute * 3,
@@ -35,8 +63,60 @@ func newHttpClient() *http.Client {
 }
 
 func (c *Client) Read() ([]byte, error) {
-	requestURL := c.url
-	req, err := http.NewRequest(http.MethodGet, requestURL, bytes.NewBuffer([]byte{}))
+	return c.get(c.url)
+}
+
+// Open the body of the URL to stream it. When the client has a cache, the body is streamed
+// from or to a body file of the cache, so it is never held in memory as a whole.
+func (c *Client) Open() (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.authorize(req)
+
+	key, entry := c.lookup(req)
+	if entry != nil && entry.fresh() {
+		return c.cache.openBody(entry)
+	}
+
+	resp, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		if entry == nil {
+			return nil, notModifiedError(req)
+		}
+		c.revalidate(req, key, entry, resp.Header)
+		return c.cache.openBody(entry)
+	}
+
+	if c.cache == nil || !cacheable(resp.Header) {
+		return resp.Body, nil
+	}
+
+	//
+	// The body is written to a new body file of the cache as the caller reads it
+	//
+
+	file, err := c.cache.createBody(key)
+	if err != nil {
+		log.Printf("Cannot cache the response of %v. %v", req.URL.Redacted(), err)
+		return resp.Body, nil
+	}
+
+	entry = &cacheEntry{URL: req.URL.String()}
+	entry.update(resp.Header)
+	return &cachingBody{body: resp.Body, file: file, cache: c.cache, key: key, entry: entry, url: req.URL.Redacted()}, nil
+}
+
+// Get the body of the URL, with the headers, credentials, retries and cache of the client
+func (c *Client) get(requestURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -45,30 +125,133 @@ func (c *Client) Read() ([]byte, error) {
 
 	req.URL.RawQuery = q.Encode()
 
-	responseBody, err := c.request(req)
+	c.authorize(req)
+
+	key, entry := c.lookup(req)
+	if entry != nil && entry.fresh() {
+		return c.cache.readBody(entry)
+	}
+
+	resp, err := c.read(req)
 	if err != nil {
 		return nil, err
 	}
 
-	return responseBody, nil
+	//
+	// A 304 response to the conditional headers of the caller, rather than of a cached body,
+	// has no body to return
+	//
+
+	if resp.statusCode == http.StatusNotModified && entry == nil {
+		return nil, notModifiedError(req)
+	}
+
+	if c.cache == nil {
+		return resp.body, nil
+	}
+
+	//
+	// A 304 response revalidates the cached body, a 200 response replaces it
+	//
+
+	if resp.statusCode == http.StatusNotModified {
+		c.revalidate(req, key, entry, resp.header)
+		return c.cache.readBody(entry)
+	}
+
+	if cacheable(resp.header) {
+		entry = &cacheEntry{URL: req.URL.String()}
+		entry.update(resp.header)
+		err = c.cache.storeBody(key, entry, resp.body)
+		if err != nil {
+			log.Printf("Cannot cache the response of %v. %v", req.URL.Redacted(), err)
+		}
+	}
+
+	return resp.body, nil
+}
+
+// The cache key and the cached entry of the request. A fresh cached body of the same request,
+// with the same headers and credentials, is used without a request, a stale one is revalidated
+// with the conditional headers set on the request.
+func (c *Client) lookup(req *http.Request) (string, *cacheEntry) {
+	if c.cache == nil {
+		return "", nil
+	}
+
+	key := cacheKey(req)
+	entry := c.cache.load(key, req.URL.String())
+	if entry != nil && !entry.fresh() {
+		entry.setConditionalHeaders(req)
+	}
+	return key, entry
+}
+
+// Update the cached entry from the headers of a 304 response
+func (c *Client) revalidate(req *http.Request, key string, entry *cacheEntry, header http.Header) {
+	entry.update(header)
+	if !cacheable(header) {
+		return
+	}
+
+	err := c.cache.store(key, entry)
+	if err != nil {
+		log.Printf("Cannot cache the response of %v. %v", req.URL.Redacted(), err)
+	}
 }
 
-func (c *Client) request(req *http.Request) ([]byte, error) {
+// Send the request, a 5xx or 429 response is retried by the retry policy. A response
+// other than 200, or 304 to a conditional request, is returned as a StatusError. The caller
+// closes the body of the response.
+func (c *Client) request(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		statusErr, ok := err.(*StatusError)
+		if !ok || !statusErr.Retryable() || attempt >= c.retry.MaxRetries {
+			return nil, err
+		}
+
+		c.sleep(c.retry.delay(attempt, statusErr.RetryAfter))
+	}
+}
+
+func (c *Client) send(req *http.Request) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	conditional := len(req.Header.Get("If-None-Match")) != 0 || len(req.Header.Get("If-Modified-Since")) != 0
+	if resp.StatusCode == http.StatusOK || (resp.StatusCode == http.StatusNotModified && conditional) {
+		return resp, nil
+	}
+
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	return nil, newStatusError(req, resp)
+}
+
+// Read the body of a 200 response
+func (c *Client) read(req *http.Request) (*response, error) {
+	resp, err := c.request(req)
+	if err != nil {
 		return nil, err
 	}
 
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &response{statusCode: resp.StatusCode, header: resp.Header}, nil
+	}
+
 	responseBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return responseBody, nil
+	return &response{statusCode: resp.StatusCode, header: resp.Header, body: responseBody}, nil
 }
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A stand-in server that responds with the statuses in order, then with 200
+type statusServer struct {
+	*httptest.Server
+	requests int32
+}
+
+func newStatusServer(t *testing.T, respond func(attempt int, w http.ResponseWriter)) *statusServer {
+	server := &statusServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := int(atomic.AddInt32(&server.requests, 1)) - 1
+		respond(attempt, w)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// A client of the server that records its retry delays instead of sleeping
+func newTestClient(url string, policy RetryPolicy, delays *[]time.Duration) *Client {
+	client := NewClient(url, WithRetryPolicy(policy))
+	client.sleep = func(delay time.Duration) {
+		*delays = append(*delays, delay)
+	}
+	return client
+}
+
+func failWith(statuses ...int) func(attempt int, w http.ResponseWriter) {
+	return func(attempt int, w http.ResponseWriter) {
+		if attempt < len(statuses) {
+			w.WriteHeader(statuses[attempt])
+			fmt.Fprintf(w, "failure %v", attempt)
+			return
+		}
+		fmt.Fprint(w, `{"ok": true}`)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	longBody := strings.Repeat("x", 2*bodyExcerptLength)
+	server := newStatusServer(t, func(attempt int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "  no such mixtape "+longBody)
+	})
+
+	var delays []time.Duration
+	_, err := newTestClient(server.URL+"/mixtape.json", DefaultRetryPolicy, &delays).Read()
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status code 404, got %v", statusErr.StatusCode)
+	}
+	if !strings.HasPrefix(statusErr.Body, "no such mixtape x") || len(statusErr.Body) > bodyExcerptLength {
+		t.Errorf("expected a trimmed body excerpt of at most %v bytes, got %q", bodyExcerptLength, statusErr.Body)
+	}
+	if !strings.Contains(statusErr.Error(), "404") || !strings.Contains(statusErr.Error(), "/mixtape.json") {
+		t.Errorf("expected the status and the URL in the error, got %v", statusErr.Error())
+	}
+}
+
+func TestRetryableStatuses(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		requests int
+		success  bool
+	}{
+		{[]int{http.StatusInternalServerError}, 2, true},
+		{[]int{http.StatusBadGateway, http.StatusServiceUnavailable}, 3, true},
+		{[]int{http.StatusTooManyRequests}, 2, true},
+		{[]int{http.StatusBadRequest}, 1, false},
+		{[]int{http.StatusUnauthorized}, 1, false},
+		{[]int{http.StatusNotFound}, 1, false},
+		{[]int{http.StatusServiceUnavailable, http.StatusForbidden}, 2, false},
+	}
+
+	for _, test := range tests {
+		server := newStatusServer(t, failWith(test.statuses...))
+
+		var delays []time.Duration
+		data, err := newTestClient(server.URL, DefaultRetryPolicy, &delays).Read()
+
+		if test.success && (err != nil || string(data) != `{"ok": true}`) {
+			t.Errorf("%v: expected the body of the 200 response, got %q, %v", test.statuses, data, err)
+		}
+		if !test.success && err == nil {
+			t.Errorf("%v: expected an error", test.statuses)
+		}
+		if int(server.requests) != test.requests {
+			t.Errorf("%v: expected %v requests, got %v", test.statuses, test.requests, server.requests)
+		}
+		if len(delays) != test.requests-1 {
+			t.Errorf("%v: expected %v retry delays, got %v", test.statuses, test.requests-1, len(delays))
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter string
+		maxDelay   time.Duration
+		delay      time.Duration
+	}{
+		{"7", 30 * time.Second, 7 * time.Second},
+		{"7", 0, 7 * time.Second},
+		{"120", 30 * time.Second, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		server := newStatusServer(t, func(attempt int, w http.ResponseWriter) {
+			if attempt == 0 {
+				w.Header().Set("Retry-After", test.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, "{}")
+		})
+
+		policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: test.maxDelay}
+		var delays []time.Duration
+		_, err := newTestClient(server.URL, policy, &delays).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(delays) != 1 || delays[0] != test.delay {
+			t.Errorf("Retry-After %v with maximum delay %v: expected a delay of %v, got %v", test.retryAfter, test.maxDelay, test.delay, delays)
+		}
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	server := newStatusServer(t, func(attempt int, w http.ResponseWriter) {
+		if attempt == 0 {
+			w.Header().Set("Retry-After", time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	})
+
+	var delays []time.Duration
+	_, err := newTestClient(server.URL, DefaultRetryPolicy, &delays).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 1 || delays[0] < 8*time.Second || delays[0] > 10*time.Second {
+		t.Errorf("expected a delay of about 10s, got %v", delays)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	for _, maxRetries := range []int{0, 1, 4} {
+		server := newStatusServer(t, func(attempt int, w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+
+		policy := RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+		var delays []time.Duration
+		_, err := newTestClient(server.URL, policy, &delays).Read()
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%v retries: expected the 503 StatusError, got %v", maxRetries, err)
+		}
+		if int(server.requests) != maxRetries+1 {
+			t.Errorf("%v retries: expected %v requests, got %v", maxRetries, maxRetries+1, server.requests)
+		}
+		if len(delays) != maxRetries {
+			t.Errorf("%v retries: expected %v delays, got %v", maxRetries, maxRetries, len(delays))
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		maxDelay time.Duration
+		attempt  int
+		backoff  time.Duration
+	}{
+		{0, 0, 100 * time.Millisecond},
+		{0, 1, 200 * time.Millisecond},
+		{0, 5, 3200 * time.Millisecond},
+		{0, 10, 102400 * time.Millisecond},
+		{time.Second, 2, 400 * time.Millisecond},
+		{time.Second, 5, time.Second},
+		{time.Second, 40, time.Second},
+		{0, 100, time.Duration(100*time.Millisecond) << 36},
+	}
+
+	for _, test := range tests {
+		policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: test.maxDelay}
+		for i := 0; i < 20; i++ {
+			// The delay is from half to all of the backoff
+			delay := policy.delay(test.attempt, 0)
+			if delay < test.backoff/2 || delay >= test.backoff {
+				t.Errorf("attempt %v with maximum delay %v: expected a delay in [%v, %v), got %v", test.attempt, test.maxDelay, test.backoff/2, test.backoff, delay)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The maximum length of the response body excerpt in a StatusError
+const bodyExcerptLength = 512
+
+// The error returned for a response other than 200, or other than 304 to the revalidation of
+// a cached body
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// The start of the response body
+	Body string
+	// The delay requested by the Retry-After header, zero when there is none
+	RetryAfter time.Duration
+}
+
+func newStatusError(req *http.Request, resp *http.Response) *StatusError {
+	excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, bodyExcerptLength))
+	return &StatusError{
+		URL:        req.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(excerpt)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// The error of a 304 response that does not revalidate a cached body
+func notModifiedError(req *http.Request) *StatusError {
+	return &StatusError{
+		URL:        req.URL.Redacted(),
+		StatusCode: http.StatusNotModified,
+		Status:     fmt.Sprintf("%v %v", http.StatusNotModified, http.StatusText(http.StatusNotModified)),
+	}
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("GET %v returned %v.", e.URL, e.Status)
+	}
+	return fmt.Sprintf("GET %v returned %v. %v", e.URL, e.Status, e.Body)
+}
+
+// A 5xx or 429 response can be retried
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Parse a Retry-After header, in seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
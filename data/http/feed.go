@@ -0,0 +1,331 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"highspot/data/validation"
+	"highspot/resources"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//
+// The feed has an endpoint for each entity, users, songs and playlists. An endpoint returns
+// a batch of entities with a stream id greater than the streamId argument, up to the limit
+// argument, in stream id order. An entity has the stream metadata, its id and action, and
+// the entity fields. See the Scaling Discussion of the README.
+//
+
+// The feed entities
+var FeedEntities = []string{"users", "songs", "playlists"}
+
+// The feed actions
+const (
+	// Add the entity, or replace it when it exists
+	FeedPut = "put"
+	// Replace the fields of an existing entity, the other fields are unchanged
+	FeedPatch = "patch"
+	// Remove the entity, when it exists
+	FeedDelete = "delete"
+)
+
+// The default number of entities in a batch
+const DefaultFeedLimit = 100
+
+// The stream metadata of a feed entity
+type FeedStream struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// A feed entity, the fields include the entity id
+type feedItem struct {
+	stream   FeedStream
+	streamID uint64
+	id       string
+	fields   map[string]json.RawMessage
+}
+
+func (i *feedItem) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, &i.fields)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(i.fields["stream"], &i.stream)
+	if err != nil {
+		return errors.New("Invalid stream metadata.")
+	}
+	delete(i.fields, "stream")
+
+	i.streamID, err = strconv.ParseUint(i.stream.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid stream id %v.", i.stream.ID)
+	}
+
+	err = json.Unmarshal(i.fields["id"], &i.id)
+	if err != nil || len(i.id) == 0 {
+		return fmt.Errorf("Stream id %v has no entity id.", i.stream.ID)
+	}
+
+	return nil
+}
+
+// The last stream id applied for each entity, the next synchronization resumes after it
+type FeedCursors map[string]uint64
+
+// Load the cursors from the file, the cursors are empty when the file does not exist
+func LoadFeedCursors(path string) (FeedCursors, error) {
+	cursors := FeedCursors{}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cursors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &cursors)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursors file %v. %w", path, err)
+	}
+	return cursors, nil
+}
+
+// Save the cursors to the file
+func (c FeedCursors) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// The number of entities applied for each entity
+type FeedCounts map[string]int
+
+// A client of the paginated feed
+type FeedClient struct {
+	client  *Client
+	baseURL string
+	limit   int
+}
+
+// Create a feed client, the options configure the requests of each batch
+func NewFeedClient(baseURL string, limit int, options ...Option) *FeedClient {
+	if limit <= 0 {
+		limit = DefaultFeedLimit
+	}
+	feed := FeedClient{
+		client:  NewClient(baseURL, options...),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		limit:   limit,
+	}
+	return &feed
+}
+
+// Synchronize the mixtape with the feed. Each entity is read in batches from its cursor
+// until the end of the feed. The batches of the entities are merged by stream id, so the
+// actions are applied in stream order, for example a playlist is changed before a song it
+// had is deleted. The cursors are advanced as the entities are applied. A removed user or
+// song is handled by the reference policy of the mixtape.
+func (f *FeedClient) Sync(mixtape *resources.MixTape, cursors FeedCursors) (FeedCounts, error) {
+	counts := FeedCounts{}
+	batches := map[string][]feedItem{}
+	ended := map[string]bool{}
+
+	for {
+		//
+		// Read the next batch of each entity that has no pending entities
+		//
+		for _, entity := range FeedEntities {
+			if len(batches[entity]) != 0 || ended[entity] {
+				continue
+			}
+
+			items, err := f.readBatch(entity, cursors[entity])
+			if err != nil {
+				return counts, err
+			}
+			batches[entity] = items
+			ended[entity] = len(items) < f.limit
+		}
+
+		//
+		// Apply the pending entity with the lowest stream id
+		//
+		next := ""
+		for _, entity := range FeedEntities {
+			if len(batches[entity]) != 0 && (len(next) == 0 || batches[entity][0].streamID < batches[next][0].streamID) {
+				next = entity
+			}
+		}
+		if len(next) == 0 {
+			return counts, nil
+		}
+
+		item := batches[next][0]
+		batches[next] = batches[next][1:]
+
+		if item.streamID <= cursors[next] {
+			return counts, fmt.Errorf("The %v stream id %v is not after the cursor %v.", next, item.streamID, cursors[next])
+		}
+
+		err := applyFeedItem(mixtape, next, &item)
+		if err != nil {
+			return counts, fmt.Errorf("Cannot apply %v stream id %v (%v %v). %w", next, item.stream.ID, item.stream.Action, item.id, err)
+		}
+
+		cursors[next] = item.streamID
+		counts[next]++
+	}
+}
+
+// Read the batch of the entity after the cursor
+func (f *FeedClient) readBatch(entity string, cursor uint64) ([]feedItem, error) {
+	query := url.Values{}
+	query.Set("streamId", strconv.FormatUint(cursor, 10))
+	query.Set("limit", strconv.Itoa(f.limit))
+
+	data, err := f.client.get(f.baseURL + "/" + entity + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var items []feedItem
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v batch. %w", entity, err)
+	}
+	return items, nil
+}
+
+// Apply the action of a feed item to the mixtape
+func applyFeedItem(mixtape *resources.MixTape, entity string, item *feedItem) error {
+	switch entity {
+	case "users":
+		return applyUser(mixtape, item)
+	case "songs":
+		return applySong(mixtape, item)
+	case "playlists":
+		return applyPlayList(mixtape, item)
+	}
+	return resources.NewError(resources.CodeUnsupported, "Unsupported feed entity %v.", entity)
+}
+
+func applyUser(mixtape *resources.MixTape, item *feedItem) error {
+	current, err := mixtape.GetUser(item.id)
+	exists := err == nil
+
+	switch item.stream.Action {
+	case FeedPut, FeedPatch:
+		var user resources.User
+		err = decodeFeedItem(item, exists, current, validation.PatchUserSchema, &user)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return mixtape.ReplaceUser(&user)
+		}
+		return mixtape.AddUser(&user)
+	case FeedDelete:
+		if !exists {
+			return nil
+		}
+		return mixtape.RemoveUser(item.id)
+	}
+	return unsupportedAction(item)
+}
+
+func applySong(mixtape *resources.MixTape, item *feedItem) error {
+	current, err := mixtape.GetSong(item.id)
+	exists := err == nil
+
+	switch item.stream.Action {
+	case FeedPut, FeedPatch:
+		var song resources.Song
+		err = decodeFeedItem(item, exists, current, validation.PatchSongSchema, &song)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return mixtape.ReplaceSong(&song)
+		}
+		return mixtape.AddSong(&song)
+	case FeedDelete:
+		if !exists {
+			return nil
+		}
+		return mixtape.RemoveSong(item.id)
+	}
+	return unsupportedAction(item)
+}
+
+func applyPlayList(mixtape *resources.MixTape, item *feedItem) error {
+	current, err := mixtape.GetPlayList(item.id)
+	exists := err == nil
+
+	switch item.stream.Action {
+	case FeedPut, FeedPatch:
+		var playlist resources.PlayList
+		err = decodeFeedItem(item, exists, current, validation.PatchPlaylistSchema, &playlist)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return mixtape.ReplacePlayList(&playlist)
+		}
+		return mixtape.AddPlayList(&playlist)
+	case FeedDelete:
+		if !exists {
+			return nil
+		}
+		return mixtape.RemovePlayList(item.id)
+	}
+	return unsupportedAction(item)
+}
+
+// Decode the entity of a put or patch item. A patch item has the fields that change, they
+// are applied to the current entity. The entity is validated against the schema.
+func decodeFeedItem(item *feedItem, exists bool, current interface{}, schema string, v interface{}) error {
+	fields := map[string]json.RawMessage{}
+
+	if item.stream.Action == FeedPatch {
+		if !exists {
+			return resources.NewError(resources.CodeNotFound, "Cannot patch %v, it does not exist.", item.id)
+		}
+		currentJSON, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(currentJSON, &fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, value := range item.fields {
+		fields[name] = value
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	err = validation.Validate(schema, string(data))
+	if err != nil {
+		return resources.WrapError(resources.CodeInvalidValue, err, "Invalid entity. %v", err)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func unsupportedAction(item *feedItem) error {
+	return resources.NewError(resources.CodeUnsupported, "Unsupported feed action %v.", item.stream.Action)
+}
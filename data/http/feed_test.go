@@ -0,0 +1,222 @@
+package http
+
+import (
+	"fmt"
+	"highspot/data/http/feedtest"
+	"highspot/resources"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newFeedServer(t *testing.T) *feedtest.Server {
+	server := feedtest.NewServer()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func syncFeed(t *testing.T, server *feedtest.Server, limit int, mixtape *resources.MixTape, cursors FeedCursors) FeedCounts {
+	counts, err := NewFeedClient(server.URL, limit).Sync(mixtape, cursors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func userIDs(t *testing.T, mixtape *resources.MixTape) []string {
+	users, err := mixtape.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestFeedSyncPages(t *testing.T) {
+	server := newFeedServer(t)
+	for i := 1; i <= 7; i++ {
+		server.Put("users", resources.User{ID: fmt.Sprint(i), Name: fmt.Sprint("User ", i)})
+	}
+	server.Put("songs", resources.Song{ID: "1", Artist: "Artist", Title: "Title"})
+
+	mixtape := resources.NewMixTape()
+	cursors := FeedCursors{}
+	counts := syncFeed(t, server, 3, mixtape, cursors)
+
+	if !reflect.DeepEqual(counts, FeedCounts{"users": 7, "songs": 1}) {
+		t.Errorf("expected 7 users and 1 song, got %v", counts)
+	}
+	if ids := userIDs(t, mixtape); !reflect.DeepEqual(ids, []string{"1", "2", "3", "4", "5", "6", "7"}) {
+		t.Errorf("expected the users in stream order, got %v", ids)
+	}
+	if !reflect.DeepEqual(cursors, FeedCursors{"users": 7, "songs": 8}) {
+		t.Errorf("expected the cursors at the last stream ids, got %v", cursors)
+	}
+
+	// The users are read in batches of 3, 3 and 1, the songs and playlists in one batch each
+	if server.Requests() != 5 {
+		t.Errorf("expected 5 batch requests, got %v", server.Requests())
+	}
+}
+
+func TestFeedSyncStreamOrder(t *testing.T) {
+	server := newFeedServer(t)
+	server.Put("users", resources.User{ID: "1", Name: "Ana"})
+	server.Put("songs", resources.Song{ID: "1", Artist: "A", Title: "One"})
+	server.Put("songs", resources.Song{ID: "2", Artist: "B", Title: "Two"})
+	server.Put("playlists", resources.PlayList{ID: "1", UserID: "1", SongIDs: []string{"1", "2"}})
+	server.Patch("playlists", "1", map[string]interface{}{"song_ids": []string{"2"}})
+	server.Delete("songs", "1")
+	server.Put("users", resources.User{ID: "2", Name: "Ben"})
+	server.Put("playlists", resources.PlayList{ID: "2", UserID: "2", SongIDs: []string{"2"}})
+
+	//
+	// Song 1 is deleted after the playlist that had it is patched, and user 2 is put before
+	// its playlist, in every batch size
+	//
+	for _, limit := range []int{1, 2, 3, 100} {
+		mixtape := resources.NewMixTape()
+		counts := syncFeed(t, server, limit, mixtape, FeedCursors{})
+
+		if !reflect.DeepEqual(counts, FeedCounts{"users": 2, "songs": 3, "playlists": 3}) {
+			t.Errorf("limit %v: unexpected counts %v", limit, counts)
+		}
+		playlists, _ := mixtape.GetPlayLists()
+		if len(playlists) != 2 || !reflect.DeepEqual(playlists[0].SongIDs, []string{"2"}) {
+			t.Errorf("limit %v: unexpected playlists %v", limit, playlists)
+		}
+		if _, err := mixtape.GetSong("1"); resources.Code(err) != resources.CodeNotFound {
+			t.Errorf("limit %v: expected song 1 to be deleted, got %v", limit, err)
+		}
+	}
+}
+
+func TestFeedSyncActions(t *testing.T) {
+	server := newFeedServer(t)
+	server.Put("users", resources.User{ID: "1", Name: "Ana"})
+	server.Put("users", resources.User{ID: "2", Name: "Ben"})
+	server.Put("songs", resources.Song{ID: "1", Artist: "A", Title: "One"})
+	server.Patch("songs", "1", map[string]interface{}{"title": "Uno"})
+	server.Put("users", resources.User{ID: "1", Name: "Ana Ruiz"})
+	server.Delete("users", "2")
+	server.Delete("users", "3")
+
+	mixtape := resources.NewMixTape()
+	syncFeed(t, server, 2, mixtape, FeedCursors{})
+
+	users, _ := mixtape.GetUsers()
+	if len(users) != 1 || *users[0] != (resources.User{ID: "1", Name: "Ana Ruiz"}) {
+		t.Errorf("expected the put to replace user 1 and the delete to remove user 2, got %v", users)
+	}
+	song, err := mixtape.GetSong("1")
+	if err != nil || *song != (resources.Song{ID: "1", Artist: "A", Title: "Uno"}) {
+		t.Errorf("expected the patch to change the title only, got %v, %v", song, err)
+	}
+}
+
+func TestFeedSyncPatchOfMissingEntity(t *testing.T) {
+	server := newFeedServer(t)
+	server.Patch("users", "1", map[string]interface{}{"name": "Ana"})
+
+	_, err := NewFeedClient(server.URL, 10).Sync(resources.NewMixTape(), FeedCursors{})
+	if resources.Code(err) != resources.CodeNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestFeedSyncResumes(t *testing.T) {
+	server := newFeedServer(t)
+	server.Put("users", resources.User{ID: "1", Name: "Ana"})
+	server.Put("users", resources.User{ID: "2", Name: "Ben"})
+
+	mixtape := resources.NewMixTape()
+	cursors := FeedCursors{}
+	syncFeed(t, server, 10, mixtape, cursors)
+
+	path := filepath.Join(t.TempDir(), "cursors.json")
+	err := cursors.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	// A user changed since the first synchronization is not put again by the second one,
+	// which only applies the new entities
+	//
+	err = mixtape.ReplaceUser(&resources.User{ID: "1", Name: "Local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Put("users", resources.User{ID: "3", Name: "Cy"})
+	server.Patch("users", "2", map[string]interface{}{"name": "Ben Lee"})
+
+	loaded, err := LoadFeedCursors(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, cursors) {
+		t.Fatalf("expected the saved cursors %v, got %v", cursors, loaded)
+	}
+
+	counts := syncFeed(t, server, 10, mixtape, loaded)
+	if !reflect.DeepEqual(counts, FeedCounts{"users": 2}) {
+		t.Errorf("expected the 2 new users only, got %v", counts)
+	}
+
+	users, _ := mixtape.GetUsers()
+	names := []string{}
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Local", "Ben Lee", "Cy"}) {
+		t.Errorf("unexpected users %v", names)
+	}
+
+	counts = syncFeed(t, server, 10, mixtape, loaded)
+	if len(counts) != 0 {
+		t.Errorf("expected nothing to apply at the end of the feed, got %v", counts)
+	}
+}
+
+func TestLoadMissingFeedCursors(t *testing.T) {
+	cursors, err := LoadFeedCursors(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(cursors) != 0 {
+		t.Errorf("expected empty cursors, got %v, %v", cursors, err)
+	}
+}
+
+func TestFeedSyncRejectsStreamIDNotAfterCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		users   string
+		cursors FeedCursors
+	}{
+		{"before the cursor", `[{"id": "1", "name": "Ana", "stream": {"id": "3", "action": "put"}}]`, FeedCursors{"users": 5}},
+		{"at the cursor", `[{"id": "1", "name": "Ana", "stream": {"id": "5", "action": "put"}}]`, FeedCursors{"users": 5}},
+		{"repeated in a batch", `[{"id": "1", "name": "Ana", "stream": {"id": "2", "action": "put"}}, {"id": "2", "name": "Ben", "stream": {"id": "2", "action": "put"}}]`, FeedCursors{}},
+	}
+
+	for _, test := range tests {
+		// A feed server that ignores the streamId argument
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Trim(r.URL.Path, "/") == "users" {
+				fmt.Fprint(w, test.users)
+				return
+			}
+			fmt.Fprint(w, "[]")
+		}))
+
+		_, err := NewFeedClient(server.URL, 10).Sync(resources.NewMixTape(), test.cursors)
+		server.Close()
+
+		if err == nil || !strings.Contains(err.Error(), "is not after the cursor") {
+			t.Errorf("%v: expected the stream id to be rejected, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package feedtest provides a fake feed server, for testing the feed client without the
+// feed service.
+package feedtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A fake feed server. The entities put, patched and deleted on the server are appended to
+// the stream of the entity, with the next stream id.
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	streamID uint64
+	streams  map[string][]map[string]interface{}
+	requests int
+}
+
+// Start a fake feed server, it is stopped by Close
+func NewServer() *Server {
+	server := Server{
+		streams: map[string][]map[string]interface{}{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveBatch))
+	return &server
+}
+
+// Append a put of the entity to the stream, the value must marshal to a JSON object with an id
+func (s *Server) Put(entity string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		panic(err)
+	}
+
+	s.append(entity, "put", fields)
+}
+
+// Append a patch of the fields of the entity to the stream
+func (s *Server) Patch(entity, id string, fields map[string]interface{}) {
+	patch := map[string]interface{}{"id": id}
+	for name, value := range fields {
+		patch[name] = value
+	}
+	s.append(entity, "patch", patch)
+}
+
+// Append a delete of the entity to the stream
+func (s *Server) Delete(entity, id string) {
+	s.append(entity, "delete", map[string]interface{}{"id": id})
+}
+
+// The number of batch requests served
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests
+}
+
+func (s *Server) append(entity, action string, fields map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.streamID++
+	fields["stream"] = map[string]string{
+		"id":     strconv.FormatUint(s.streamID, 10),
+		"action": action,
+	}
+	s.streams[entity] = append(s.streams[entity], fields)
+}
+
+// Serve the batch of the entities after the streamId argument, up to the limit argument
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests++
+
+	entity := strings.Trim(r.URL.Path, "/")
+	stream, ok := s.streams[entity]
+	if !ok && entity != "users" && entity != "songs" && entity != "playlists" {
+		http.NotFound(w, r)
+		return
+	}
+
+	cursor, err := strconv.ParseUint(r.URL.Query().Get("streamId"), 10, 64)
+	if err != nil {
+		cursor = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	batch := []map[string]interface{}{}
+	for _, fields := range stream {
+		streamID, _ := strconv.ParseUint(fields["stream"].(map[string]string)["id"], 10, 64)
+		if streamID > cursor && len(batch) < limit {
+			batch = append(batch, fields)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// The retry policy for 5xx and 429 responses
+type RetryPolicy struct {
+	// The number of retries after the first request
+	MaxRetries int
+	// The delay before the first retry, it doubles for each retry
+	BaseDelay time.Duration
+	// The maximum delay before a retry, including a Retry-After delay
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+}
+
+// Set the retry policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// The delay before a retry. The Retry-After delay of the response is used when there
+// is one, otherwise the delay is an exponential backoff with jitter, from half to all
+// of the backoff.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return p.limit(retryAfter)
+	}
+
+	// A policy without a maximum delay backs off until the delay would overflow
+	backoff := p.BaseDelay
+	for i := 0; i < attempt && backoff <= math.MaxInt64/2 && (p.MaxDelay <= 0 || backoff < p.MaxDelay); i++ {
+		backoff *= 2
+	}
+	backoff = p.limit(backoff)
+
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+func (p RetryPolicy) limit(delay time.Duration) time.Duration {
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
@@ -1,20 +1,23 @@
 package data
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"highspot/data/validation"
 	"highspot/resources"
 	"log"
-	"regexp"
 )
 
 type Ingester struct {
 	inputReader   Reader
 	changesReader Reader
 	outputWriter  Writer
+	undoWriter    Writer
+	applyMode     ApplyMode
+	sortMode      resources.SortMode
+	refPolicy     resources.ReferencePolicy
+	ingestMode    resources.IngestMode
+	changesFormat ChangesFormat
+	streaming     bool
+	storage       resources.Storage
 }
 
 func NewIngestor(inputReader Reader, changesReader Reader, outputWriter Writer) *Ingester {
@@ -26,95 +29,168 @@ func NewIngestor(inputReader Reader, changesReader Reader, outputWriter Writer)
 	return &ingestor
 }
 
+// Set the apply mode, the default is transactional
+func (i *Ingester) SetApplyMode(mode ApplyMode) {
+	i.applyMode = mode
+}
+
+// Set the order of the users, songs and playlists in the output, the default is the input order
+func (i *Ingester) SetSortMode(mode resources.SortMode) {
+	i.sortMode = mode
+}
+
+// Set the policy for the playlists of a removed user or song, the default is to reject the change
+func (i *Ingester) SetReferencePolicy(policy resources.ReferencePolicy) {
+	i.refPolicy = policy
+}
+
+// Set how invalid playlists in the input are handled, the default is to drop and report them
+func (i *Ingester) SetIngestMode(mode resources.IngestMode) {
+	i.ingestMode = mode
+}
+
+// Set the writer of the undo changes, which turn the output back into the input. The undo
+// changes are not written by default.
+func (i *Ingester) SetUndoWriter(writer Writer) {
+	i.undoWriter = writer
+}
+
+// Set the format of the changes file, the default is to detect it from the document
+func (i *Ingester) SetChangesFormat(format ChangesFormat) {
+	i.changesFormat = format
+}
+
+// Stream the input instead of reading it as a whole, when the input reader is a stream reader
+func (i *Ingester) SetStreaming(streaming bool) {
+	i.streaming = streaming
+}
+
+// Set the storage of the mixtape, the default is the in-memory storage. The input is streamed
+// into the storage.
+func (i *Ingester) SetStorage(storage resources.Storage) {
+	i.storage = storage
+}
+
 //
 // For this exercise, you will write 3 functions for a command-line batch application.
 // The three functions are ingestInput, ingestChanges, produceOutput
 //
-func (i *Ingester) Execute() error {
+// The report has the ingestion issues of the input file and the result of each change, it is
+// returned with the error when the input is ingested but the output cannot be produced.
+func (i *Ingester) Execute() (*Report, error) {
 	//
 	// Ingest an input JSON file which we will provide, mixtape.json.
 	//
 	mixtape, err := i.ingestInput()
 	if err != nil {
-		return errors.New(fmt.Sprintf("Ingest input failed. %v", err))
+		return nil, fmt.Errorf("Ingest input failed. %w", err)
+	}
+
+	report := &Report{
+		Ingestion: mixtape.IngestionIssues(),
+	}
+	for _, issue := range report.Ingestion {
+		log.Printf("Playlist ID %v %v. %v", issue.PlayListID, issue.Action, issue.Message)
 	}
 
 	//
 	// Ingest a changes file which you will create.
 	//
-	changes, err := i.ingestChanges()
+	changes, err := i.ingestChanges(mixtape)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Ingest changes failed. %v", err))
+		return report, fmt.Errorf("Ingest changes failed. %w", err)
 	}
 
 	//
 	// Produce output.json which must have the same structure as the mixtape.json input.
 	//
-	err = i.produceOutput(mixtape, changes)
+	err = i.produceOutput(mixtape, changes, report)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Produce output failed. %v", err))
+		return report, fmt.Errorf("Produce output failed. %w", err)
 	}
 
-	return nil
+	return report, nil
 }
 
 //
 // Ingest and validate the input file.
 //
 func (i *Ingester) ingestInput() (*resources.MixTape, error) {
+	if streamReader, ok := i.inputReader.(StreamReader); ok && (i.streaming || i.storage != nil) {
+		return i.streamInput(streamReader)
+	}
+
 	//
 	// Read and validate the input json document
 	//
 
 	data, err := i.readInput()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Cannot read input file. %v.", err))
+		return nil, fmt.Errorf("Cannot read input file. %w.", err)
 	}
 
-	err = validation.Validate(validation.InputSchema, string(data))
+	//
+	// Validate and unmarshal (deserialize) the input json document
+	//
+
+	mixtape, err := DecodeMixTape(data, i.ingestMode)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Invalid input file. %v", err))
+		return nil, err
 	}
 
-	//
-	// Unmarshal (deserialize) input json document and validate
-	//
+	mixtape.SetSortMode(i.sortMode)
+	mixtape.SetReferencePolicy(i.refPolicy)
+
+	return mixtape, nil
+}
 
-	var mixtape resources.MixTape
-	err = json.Unmarshal(data, &mixtape)
+//
+// Stream and validate the input file, one user, song or playlist at a time.
+//
+func (i *Ingester) streamInput(streamReader StreamReader) (*resources.MixTape, error) {
+	input, err := streamReader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read input file. %w.", err)
+	}
+	defer input.Close()
+
+	mixtape := resources.NewMixTape()
+	if i.storage != nil {
+		mixtape = resources.NewMixTapeWithStorage(i.storage)
+	}
+	mixtape.SetIngestMode(i.ingestMode)
+	err = StreamMixTape(input, mixtape)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Invalid input file. %v", err))
+		return nil, fmt.Errorf("Invalid input file. %w", err)
 	}
 
-	return &mixtape, nil
+	mixtape.SetSortMode(i.sortMode)
+	mixtape.SetReferencePolicy(i.refPolicy)
+
+	return mixtape, nil
 }
 
 //
-// Ingest and validate the changes file
+// Ingest and validate the changes file, a merge patch is translated into the JSON patch of the
+// mixtape.
 //
-func (i *Ingester) ingestChanges() ([]resources.Change, error) {
+func (i *Ingester) ingestChanges(mixtape *resources.MixTape) ([]resources.Change, error) {
 	//
 	// Read and validate the input json document
 	//
 
 	data, err := i.readChanges()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Cannot read changes file. %v", err))
-	}
-
-	err = validation.Validate(validation.PatchSchema, string(data))
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Invalid changes file. %v", err))
+		return nil, fmt.Errorf("Cannot read changes file. %w", err)
 	}
 
 	//
-	// Unmarshal (deserialize) input json document and validate
+	// Validate and unmarshal (deserialize) the changes json document
 	//
 
-	var changes []resources.Change
-	err = json.Unmarshal(data, &changes)
+	changes, err := DecodeChangesFormat(data, i.changesFormat, mixtape)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Invalid changes file. %v", err))
+		return nil, fmt.Errorf("Invalid changes file. %w", err)
 	}
 
 	return changes, nil
@@ -123,135 +199,34 @@ func (i *Ingester) ingestChanges() ([]resources.Change, error) {
 //
 // Apply the changes and generate the output file
 //
-func (i *Ingester) produceOutput(mixtape *resources.MixTape, changes []resources.Change) error {
+func (i *Ingester) produceOutput(mixtape *resources.MixTape, changes []resources.Change, report *Report) error {
 	//
 	// Apply the changes
 	//
-	err := i.applyChanges(mixtape, changes)
+	var undo []resources.Change
+	var err error
+	report.Changes, undo, err = i.applyChanges(mixtape, changes)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot apply changes. %v", err))
+		return fmt.Errorf("Cannot apply changes. %w", err)
 	}
 
 	//
-	// Write the output file
+	// Write the output file, and the undo file
 	//
-	data, err := json.Marshal(mixtape)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot write output file. %v", err))
-	}
-
-	var prettyJSON bytes.Buffer
-	err = json.Indent(&prettyJSON, data, "", "  ")
-	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot write output file. %v", err))
-	}
-
-	err = i.writeOutput(prettyJSON.Bytes())
-	if err != nil {
-		return errors.New(fmt.Sprintf("Cannot write output file. %v", err))
+	err = i.writeOutput(mixtape)
+	if err != nil || i.undoWriter == nil {
+		return err
 	}
-
-	return nil
+	return i.writeUndo(undo)
 }
 
-func (i *Ingester) applyChanges(mixtape *resources.MixTape, changes []resources.Change) error {
-	//
-	// Loop over the changes and apply each change to the mixtape data model
-	//
-	for _, change := range changes {
-		if change.Op == "add" {
-			//
-			// Add a new playlist; the playlist should contain at least one song.
-			//
-
-			ok, err := regexp.MatchString("/playlists/-", change.Path)
-			if err != nil {
-				return err
-			}
-			if ok {
-				err = i.applyAddPlaylist(mixtape, &change)
-				if err != nil {
-					log.Printf("Skipping add playlist. %v", err)
-				}
-				continue
-			}
-
-			//
-			// Add an existing song to an existing playlist
-			//
-
-			ok, err = regexp.MatchString("/playlists/[0-9]+/song_ids/-", change.Path)
-			if err != nil {
-				return err
-			}
-			if ok {
-				err = i.applyAddSongToPlaylist(mixtape, &change)
-				if err != nil {
-					log.Printf("Skipping add song to playlist. %v", err)
-				}
-			}
-		} else if change.Op == "remove" {
-			//
-			// Remove a playlist.
-			//
-
-			ok, err := regexp.MatchString("/playlists/[0-9]+", change.Path)
-			if err != nil {
-				return err
-			}
-			if ok {
-				err = i.applyRemovePlaylist(mixtape, &change)
-				if err != nil {
-					log.Printf("Skipping remove playlist. %v", err)
-				}
-			}
-		}
+func (i *Ingester) applyChanges(mixtape *resources.MixTape, changes []resources.Change) ([]ChangeResult, []resources.Change, error) {
+	if i.undoWriter != nil {
+		return ApplyChangesWithUndo(mixtape, changes, i.applyMode)
 	}
 
-	return nil
-}
-
-func (i *Ingester) applyAddPlaylist(mixtape *resources.MixTape, change *resources.Change) error {
-	if change.Value == nil {
-		return errors.New("Missing playlist value.")
-	}
-
-	playlistJSON, err := json.Marshal(change.Value)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Invalid playlist value. %v", err))
-	}
-
-	err = validation.Validate(validation.PatchPlaylistSchema, string(playlistJSON))
-	if err != nil {
-		return errors.New(fmt.Sprintf("Invalid playlist value. %v", err))
-	}
-
-	var playlist resources.PlayList
-	err = json.Unmarshal(playlistJSON, &playlist)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Invalid playlist value. %v", err))
-	}
-
-	return mixtape.AddPlayList(&playlist)
-}
-
-func (i *Ingester) applyRemovePlaylist(mixtape *resources.MixTape, change *resources.Change) error {
-	re := regexp.MustCompile("/playlists/([0-9]+)")
-	match := re.FindStringSubmatch(change.Path)
-	return mixtape.RemovePlayList(match[1])
-}
-
-func (i *Ingester) applyAddSongToPlaylist(mixtape *resources.MixTape, change *resources.Change) error {
-	re := regexp.MustCompile("/playlists/([0-9]+)/song_ids/-")
-	if change.Value == nil {
-		return errors.New("Missing song ID value.")
-	}
-	songID, ok := change.Value.(string)
-	if !ok {
-		return errors.New("Invalid song ID value.")
-	}
-	match := re.FindStringSubmatch(change.Path)
-	return mixtape.AddSongToPlayList(match[1], songID)
+	results, err := ApplyChanges(mixtape, changes, i.applyMode)
+	return results, nil, err
 }
 
 func (i *Ingester) readInput() ([]byte, error) {
@@ -270,6 +245,19 @@ func (i *Ingester) readChanges() ([]byte, error) {
 	return data, nil
 }
 
-func (i *Ingester) writeOutput(data []byte) error {
-	return i.outputWriter.Write(data)
+func (i *Ingester) writeOutput(mixtape *resources.MixTape) error {
+	return WriteOutput(i.outputWriter, mixtape)
+}
+
+func (i *Ingester) writeUndo(undo []resources.Change) error {
+	data, err := EncodeChanges(undo)
+	if err != nil {
+		return err
+	}
+
+	err = i.undoWriter.Write(data)
+	if err != nil {
+		return fmt.Errorf("Cannot write undo file. %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"errors"
+	"highspot/resources"
+	"reflect"
+	"testing"
+)
+
+// A reader of a document in memory
+type memoryReader string
+
+func (r memoryReader) Read() ([]byte, error) {
+	return []byte(r), nil
+}
+
+// A writer that keeps the written document in memory
+type memoryWriter struct {
+	data    []byte
+	written bool
+}
+
+func (w *memoryWriter) Write(data []byte) error {
+	w.data = data
+	w.written = true
+	return nil
+}
+
+func TestIngesterTransactional(t *testing.T) {
+	changes := `[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "remove", "path": "/users/9"}
+	]`
+
+	output := &memoryWriter{}
+	ingester := NewIngestor(memoryReader(patchTestMixTape), memoryReader(changes), output)
+
+	_, err := ingester.Execute()
+	var changeErr *ChangeError
+	if !errors.As(err, &changeErr) || changeErr.Index != 1 {
+		t.Fatalf("expected the error of change 1, got %v", err)
+	}
+	if output.written {
+		t.Errorf("expected no output file, got %s", output.data)
+	}
+
+	//
+	// In best-effort mode the output has the changes that are applied
+	//
+
+	ingester.SetApplyMode(BestEffort)
+	_, err = ingester.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixtape, err := DecodeMixTape(output.data, resources.IngestStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := mixtape.GetUser("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Albin" {
+		t.Errorf("expected the applied change in the output, got %v", user.Name)
+	}
+}
+
+func TestIngesterReport(t *testing.T) {
+	changes := `[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "remove", "path": "/users/9"},
+		{"op": "remove", "path": "/playlists/2"}
+	]`
+
+	tests := []struct {
+		mode     ApplyMode
+		statuses []string
+	}{
+		{Transactional, []string{"skipped rolled_back", "failed not_found", "skipped aborted"}},
+		{BestEffort, []string{"applied ", "failed not_found", "applied "}},
+	}
+
+	for _, test := range tests {
+		ingester := NewIngestor(memoryReader(patchTestMixTape), memoryReader(changes), &memoryWriter{})
+		ingester.SetApplyMode(test.mode)
+
+		// The report is returned with the error of a failed patch
+		report, err := ingester.Execute()
+		if (err == nil) != (test.mode == BestEffort) {
+			t.Errorf("mode %v: unexpected error %v", test.mode, err)
+		}
+		if report == nil {
+			t.Fatalf("mode %v: expected a report", test.mode)
+		}
+
+		if actual := resultStatuses(report.Changes); !reflect.DeepEqual(actual, test.statuses) {
+			t.Errorf("mode %v: expected the results %v, got %v", test.mode, test.statuses, actual)
+		}
+		if report.Changes[1].Index != 1 || report.Changes[1].Op != "remove" || report.Changes[1].Path != "/users/9" {
+			t.Errorf("mode %v: expected the index, op and path of change 1, got %+v", test.mode, report.Changes[1])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"encoding/json"
+	"highspot/resources"
+)
+
+// The resources.Storage methods of the store
+
+func (s *Store) GetUser(userID string) (*resources.User, error) {
+	var user resources.User
+	found, err := s.get("users", userID, &user)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Store) PutUser(user *resources.User) error {
+	return s.put("users", user.ID, user)
+}
+
+func (s *Store) DeleteUser(userID string) error {
+	return s.delete("users", userID)
+}
+
+func (s *Store) ForEachUser(fn func(user *resources.User) error) error {
+	return s.forEach("users", func(value json.RawMessage) error {
+		var user resources.User
+		err := json.Unmarshal(value, &user)
+		if err != nil {
+			return err
+		}
+		return fn(&user)
+	})
+}
+
+func (s *Store) GetSong(songID string) (*resources.Song, error) {
+	var song resources.Song
+	found, err := s.get("songs", songID, &song)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (s *Store) PutSong(song *resources.Song) error {
+	return s.put("songs", song.ID, song)
+}
+
+func (s *Store) DeleteSong(songID string) error {
+	return s.delete("songs", songID)
+}
+
+func (s *Store) ForEachSong(fn func(song *resources.Song) error) error {
+	return s.forEach("songs", func(value json.RawMessage) error {
+		var song resources.Song
+		err := json.Unmarshal(value, &song)
+		if err != nil {
+			return err
+		}
+		return fn(&song)
+	})
+}
+
+func (s *Store) GetPlayList(playlistID string) (*resources.PlayList, error) {
+	var playlist resources.PlayList
+	found, err := s.get("playlists", playlistID, &playlist)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func (s *Store) PutPlayList(playlist *resources.PlayList) error {
+	return s.put("playlists", playlist.ID, playlist)
+}
+
+func (s *Store) DeletePlayList(playlistID string) error {
+	return s.delete("playlists", playlistID)
+}
+
+func (s *Store) ForEachPlayList(fn func(playlist *resources.PlayList) error) error {
+	return s.forEach("playlists", func(value json.RawMessage) error {
+		var playlist resources.PlayList
+		err := json.Unmarshal(value, &playlist)
+		if err != nil {
+			return err
+		}
+		return fn(&playlist)
+	})
+}
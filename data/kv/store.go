@@ -0,0 +1,203 @@
+// Package kv is an on-disk key/value storage of the users, songs and playlists of a
+// mixtape, for mixtapes that do not fit in memory.
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"highspot/resources"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//
+// Each collection has two buckets. The entities bucket maps the ID of an entity to a record
+// with its insertion sequence and its JSON value, and the order bucket maps the big-endian
+// sequence to the ID, so a cursor over the order bucket iterates in insertion order.
+//
+
+var collections = []string{"users", "songs", "playlists"}
+
+// A record of the entities bucket
+type record struct {
+	Seq   uint64          `json:"seq"`
+	Value json.RawMessage `json:"value"`
+}
+
+// The on-disk storage. A change outside of Update is written in its own transaction.
+type Store struct {
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+// Create the store file, an existing file is replaced. The store is the working storage of
+// a run, it is not synced to disk on each change.
+func Create(path string) (*Store, error) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = true
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, collection := range collections {
+			_, err := tx.CreateBucket([]byte(collection))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(collection + ".order"))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close the store file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+// Run fn in a single transaction, the changes of fn are written atomically
+func (s *Store) Update(fn func(storage resources.Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&Store{db: s.db, tx: tx})
+	})
+}
+
+// Get the value of an entity, false when it does not exist
+func (s *Store) get(collection, id string, v interface{}) (bool, error) {
+	found := false
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(collection)).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+
+		var r record
+		err := json.Unmarshal(data, &r)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(r.Value, v)
+	})
+	return found, err
+}
+
+// Put an entity, a new entity gets the next sequence of the collection
+func (s *Store) put(collection, id string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		entities := tx.Bucket([]byte(collection))
+		order := tx.Bucket([]byte(collection + ".order"))
+
+		var r record
+		if data := entities.Get([]byte(id)); data != nil {
+			err := json.Unmarshal(data, &r)
+			if err != nil {
+				return err
+			}
+		} else {
+			r.Seq, err = order.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = order.Put(seqKey(r.Seq), []byte(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		r.Value = value
+		data, err := json.Marshal(&r)
+		if err != nil {
+			return err
+		}
+		return entities.Put([]byte(id), data)
+	})
+}
+
+func (s *Store) delete(collection, id string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		entities := tx.Bucket([]byte(collection))
+		data := entities.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		var r record
+		err := json.Unmarshal(data, &r)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(collection + ".order")).Delete(seqKey(r.Seq))
+		if err != nil {
+			return err
+		}
+		return entities.Delete([]byte(id))
+	})
+}
+
+// Iterate the values of a collection in insertion order. The collection cannot be changed
+// by fn.
+func (s *Store) forEach(collection string, fn func(value json.RawMessage) error) error {
+	return s.view(func(tx *bolt.Tx) error {
+		entities := tx.Bucket([]byte(collection))
+		cursor := tx.Bucket([]byte(collection + ".order")).Cursor()
+
+		for _, id := cursor.First(); id != nil; _, id = cursor.Next() {
+			var r record
+			err := json.Unmarshal(entities.Get(id), &r)
+			if err != nil {
+				return err
+			}
+			err = fn(r.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
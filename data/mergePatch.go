@@ -0,0 +1,223 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"highspot/data/validation"
+	"highspot/resources"
+	"strings"
+)
+
+//
+// A JSON merge patch (RFC 7396) of the mixtape document. The users, songs and playlists of
+// a merge patch are objects keyed by ID, as they are addressed by the JSON pointers of a
+// JSON patch, rather than arrays:
+//
+//   {
+//     "users": {"7": {"name": "Ana Ruiz"}, "8": {"name": "Sam Lee"}, "3": null},
+//     "playlists": {"2": {"song_ids": ["1", "4"]}}
+//   }
+//
+// A null entity is removed. Otherwise the entity is merged into the existing entity with the
+// same ID, or into a new entity, and the result must be a valid entity. An array, like the
+// song IDs of a playlist, is replaced as a whole.
+//
+// A merge patch is translated into a JSON patch, with an add of each merged entity and a
+// remove of each null entity, so it is applied, validated and reported as a JSON patch. The
+// entities are added before the entities are removed, users and songs before playlists and
+// playlists before users and songs, so a user or song is added before a playlist uses it,
+// and removed after the playlists that used it are changed.
+//
+
+// The changes format is the format of a changes file
+type ChangesFormat int
+
+const (
+	// A JSON patch for an array, a merge patch for an object
+	DetectFormat ChangesFormat = iota
+	// A JSON patch (RFC 6902)
+	JSONPatchFormat
+	// A JSON merge patch (RFC 7396)
+	MergePatchFormat
+)
+
+// Parse the command line name of a changes format
+func ParseChangesFormat(name string) (ChangesFormat, error) {
+	switch name {
+	case "detect":
+		return DetectFormat, nil
+	case "json-patch":
+		return JSONPatchFormat, nil
+	case "merge-patch":
+		return MergePatchFormat, nil
+	}
+	return DetectFormat, resources.NewError(resources.CodeInvalidValue, "Unknown changes format %v.", name)
+}
+
+// The order of the changes of a merge patch
+var mergePhases = []struct {
+	collection string
+	removed    bool
+}{
+	{"users", false},
+	{"songs", false},
+	{"playlists", false},
+	{"playlists", true},
+	{"songs", true},
+	{"users", true},
+}
+
+// Validate and unmarshal a changes document of the format. A merge patch is translated into
+// the JSON patch of the mixtape.
+func DecodeChangesFormat(data []byte, format ChangesFormat, mixtape *resources.MixTape) ([]resources.Change, error) {
+	if format == DetectFormat {
+		format = JSONPatchFormat
+		if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '{' {
+			format = MergePatchFormat
+		}
+	}
+
+	if format == MergePatchFormat {
+		return DecodeMergePatch(data, mixtape)
+	}
+	return DecodeChanges(data)
+}
+
+// Validate a merge patch and translate it into the JSON patch of the mixtape
+func DecodeMergePatch(data []byte, mixtape *resources.MixTape) ([]resources.Change, error) {
+	err := validation.Validate(validation.MergePatchSchema, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]json.RawMessage
+	err = json.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := map[string][]mergeEntity{}
+	for collection, value := range document {
+		collections[collection], err = decodeMergeEntities(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := []resources.Change{}
+	for _, phase := range mergePhases {
+		for _, entity := range collections[phase.collection] {
+			if (entity.patch == nil) != phase.removed {
+				continue
+			}
+
+			if phase.removed {
+				changes = append(changes, removeChange(phase.collection, entity.id))
+				continue
+			}
+
+			value, err := mergedEntity(mixtape, phase.collection, entity)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, resources.Change{Op: "add", Path: entityPath(phase.collection, entity.id), Value: value})
+		}
+	}
+
+	return changes, nil
+}
+
+// An entity of a merge patch, the patch is nil for a removed entity
+type mergeEntity struct {
+	id    string
+	patch map[string]interface{}
+}
+
+// Decode the entities of a collection in the order of the merge patch
+func decodeMergeEntities(data json.RawMessage) ([]mergeEntity, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	_, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	entities := []mergeEntity{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		entity := mergeEntity{id: token.(string)}
+		err = decoder.Decode(&entity.patch)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+// Merge the patch of an entity into the existing entity with its ID, or into a new entity
+func mergedEntity(mixtape *resources.MixTape, collection string, entity mergeEntity) (interface{}, error) {
+	target := map[string]interface{}{"id": entity.id}
+
+	current, err := getEntity(mixtape, collection, entity.id)
+	if err != nil && resources.Code(err) != resources.CodeNotFound {
+		return nil, err
+	}
+	if err == nil {
+		target = map[string]interface{}{}
+		data, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mergePatch(target, entity.patch), nil
+}
+
+func getEntity(mixtape *resources.MixTape, collection, id string) (interface{}, error) {
+	switch collection {
+	case "users":
+		return mixtape.GetUser(id)
+	case "songs":
+		return mixtape.GetSong(id)
+	}
+	return mixtape.GetPlayList(id)
+}
+
+// Merge a patch into a target value as RFC 7396 specifies
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+
+	return targetObject
+}
+
+// Escape a reference token of a JSON pointer
+func escapePointerToken(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"encoding/json"
+	"highspot/data/validation"
+	"highspot/resources"
+	"testing"
+)
+
+func TestDecodeMergePatch(t *testing.T) {
+	//
+	// Users and songs are added before the playlists, and removed after them, so song 2 is
+	// removed once playlist 1 no longer has it
+	//
+	patch := `{
+		"songs": {"2": null},
+		"playlists": {
+			"1": {"song_ids": ["3"]},
+			"2": null,
+			"9": {"user_id": "8", "song_ids": ["1", "3"]}
+		},
+		"users": {
+			"1": {"name": "Albin"},
+			"8": {"name": "Ana"}
+		}
+	}`
+
+	mixtape := newPatchTestMixTape(t)
+	changes, err := DecodeMergePatch([]byte(patch), mixtape)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[
+		{"op": "add", "path": "/users/1", "value": {"id": "1", "name": "Albin"}},
+		{"op": "add", "path": "/users/8", "value": {"id": "8", "name": "Ana"}},
+		{"op": "add", "path": "/playlists/1", "value": {"id": "1", "user_id": "1", "song_ids": ["3"]}},
+		{"op": "add", "path": "/playlists/9", "value": {"id": "9", "user_id": "8", "song_ids": ["1", "3"]}},
+		{"op": "remove", "path": "/playlists/2"},
+		{"op": "remove", "path": "/songs/2"}
+	]`
+	expectedChanges, err := DecodeChanges([]byte(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actualJSON, _ := json.Marshal(changes)
+	expectedJSON, _ := json.Marshal(expectedChanges)
+	if string(actualJSON) != string(expectedJSON) {
+		t.Fatalf("expected the changes\n%s\ngot\n%s", expectedJSON, actualJSON)
+	}
+
+	_, err = ApplyChanges(mixtape, changes, Transactional)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, value := range map[string]string{
+		"/users/1/name":         `"Albin"`,
+		"/playlists/1/song_ids": `["3"]`,
+		"/playlists/9/user_id":  `"8"`,
+		"/songs":                `[{"id": "1", "artist": "Camila Cabello", "title": "Never Be the Same"}, {"id": "3", "artist": "The Weeknd", "title": "Pray For Me"}]`,
+	} {
+		actual, err := getValue(mixtape, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedValue interface{}
+		json.Unmarshal([]byte(value), &expectedValue)
+		if equal, _ := jsonEqual(actual, expectedValue); !equal {
+			t.Errorf("expected %v at %v, got %v", value, path, actual)
+		}
+	}
+}
+
+func TestDecodeInvalidMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		code  resources.ErrorCode
+	}{
+		// A null field is removed from the entity, a user without a name is invalid
+		{"a null field", `{"users": {"1": {"name": null}}}`, resources.CodeInvalidValue},
+		{"an unknown field", `{"songs": {"1": {"album": "Camila"}}}`, resources.CodeInvalidValue},
+		{"a new user without a name", `{"users": {"3": {}}}`, resources.CodeInvalidValue},
+		{"a referenced song", `{"songs": {"1": null}}`, resources.CodeReferenced},
+	}
+
+	for _, test := range tests {
+		mixtape := newPatchTestMixTape(t)
+		changes, err := DecodeMergePatch([]byte(test.patch), mixtape)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		_, err = ApplyChanges(mixtape, changes, Transactional)
+		if resources.Code(err) != test.code {
+			t.Errorf("%v: expected the code %v, got %v", test.name, test.code, err)
+		}
+	}
+
+	// A merge patch of the mixtape is an object of the collections, keyed by id
+	for _, patch := range []string{`{"users": [{"id": "1"}]}`, `{"artists": {}}`, `{"users": {"1": "Albin"}}`} {
+		_, err := DecodeMergePatch([]byte(patch), newPatchTestMixTape(t))
+		if len(validation.Violations(err)) == 0 {
+			t.Errorf("%v: expected the violations of the merge patch schema, got %v", patch, err)
+		}
+	}
+}
+
+func TestDecodeChangesFormat(t *testing.T) {
+	patch := `[{"op": "remove", "path": "/playlists/2"}]`
+	mergePatch := ` {"playlists": {"2": null}}`
+
+	tests := []struct {
+		name   string
+		data   string
+		format ChangesFormat
+		valid  bool
+	}{
+		{"detect a JSON patch", patch, DetectFormat, true},
+		{"detect a merge patch", mergePatch, DetectFormat, true},
+		{"a JSON patch", patch, JSONPatchFormat, true},
+		{"a merge patch", mergePatch, MergePatchFormat, true},
+		{"a merge patch as a JSON patch", mergePatch, JSONPatchFormat, false},
+		{"a JSON patch as a merge patch", patch, MergePatchFormat, false},
+	}
+
+	for _, test := range tests {
+		changes, err := DecodeChangesFormat([]byte(test.data), test.format, newPatchTestMixTape(t))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", test.name, changes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if len(changes) != 1 || changes[0].Op != "remove" || changes[0].Path != "/playlists/2" {
+			t.Errorf("%v: expected the removal of playlist 2, got %v", test.name, changes)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396, appendix A
+	tests := []struct {
+		target, patch, result string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, test := range tests {
+		var target, patch, expected interface{}
+		json.Unmarshal([]byte(test.target), &target)
+		json.Unmarshal([]byte(test.patch), &patch)
+		json.Unmarshal([]byte(test.result), &expected)
+
+		actual := mergePatch(target, patch)
+		if equal, _ := jsonEqual(actual, expected); !equal {
+			actualJSON, _ := json.Marshal(actual)
+			t.Errorf("%v merged with %v: expected %v, got %s", test.target, test.patch, test.result, actualJSON)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"highspot/data/validation"
+	"highspot/resources"
+	"log"
+	"reflect"
+	"strings"
+)
+
+//
+// The JSON patch (RFC 6902) operations are implemented in terms of the get, add, remove
+// and replace primitives of the node a JSON pointer resolves to, see pointer.go.
+//
+
+// The apply mode controls what happens when a change cannot be applied
+type ApplyMode int
+
+const (
+	// All of the changes are applied, or none of them
+	Transactional ApplyMode = iota
+	// A change that cannot be applied is skipped, a failed test aborts the patch
+	BestEffort
+)
+
+// Parse the command line name of an apply mode
+func ParseApplyMode(name string) (ApplyMode, error) {
+	switch name {
+	case "transactional":
+		return Transactional, nil
+	case "best-effort":
+		return BestEffort, nil
+	}
+	return Transactional, resources.NewError(resources.CodeInvalidValue, "Unknown apply mode %v.", name)
+}
+
+// A change error reports the change that could not be applied
+type ChangeError struct {
+	Index  int
+	Change resources.Change
+	Err    error
+}
+
+func (e *ChangeError) Error() string {
+	return fmt.Sprintf("Change %v (%v %v) failed. %v", e.Index, e.Change.Op, e.Change.Path, e.Err)
+}
+
+func (e *ChangeError) Unwrap() error {
+	return e.Err
+}
+
+// Apply the changes to the mixtape and return the result of each change. In transactional
+// mode the changes are applied to a copy of the mixtape which is committed only when every
+// change succeeds. In best-effort mode each change is applied to its own copy, so a change
+// that fails halfway, like a move that cannot add the removed value, leaves nothing behind.
+func ApplyChanges(mixtape *resources.MixTape, changes []resources.Change, mode ApplyMode) ([]ChangeResult, error) {
+	results := newChangeResults(changes)
+
+	target := mixtape
+	if mode == Transactional {
+		target = mixtape.Begin()
+	}
+
+	for index, change := range changes {
+		var err error
+		if mode == Transactional {
+			err = applyChange(target, &change)
+		} else {
+			err = applyChangeAtomically(target, &change)
+		}
+		if err == nil {
+			results[index].setApplied()
+			continue
+		}
+
+		results[index].setFailed(err)
+
+		if mode == Transactional || change.Op == "test" {
+			abortChanges(results, index, mode)
+			return results, &ChangeError{Index: index, Change: change, Err: err}
+		}
+
+		log.Printf("Skipping change %v (%v %v). %v", index, change.Op, change.Path, err)
+	}
+
+	if mode == Transactional {
+		return results, target.Commit()
+	}
+
+	return results, nil
+}
+
+// Mark the results of the changes that are not applied when the patch is aborted by a change
+func abortChanges(results []ChangeResult, failed int, mode ApplyMode) {
+	for index := range results {
+		if index < failed && mode == Transactional {
+			results[index].setSkipped(resources.CodeRolledBack, fmt.Sprintf("Rolled back, change %v failed.", failed))
+		} else if index > failed {
+			results[index].setSkipped(resources.CodeAborted, fmt.Sprintf("Not applied, change %v failed.", failed))
+		}
+	}
+}
+
+// Apply a single change to a transaction of the mixtape, which is discarded when the change fails
+func applyChangeAtomically(mixtape *resources.MixTape, change *resources.Change) error {
+	tx := mixtape.Begin()
+	err := applyChange(tx, change)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Apply a single change to the mixtape
+func applyChange(mixtape *resources.MixTape, change *resources.Change) error {
+	switch change.Op {
+	case "add":
+		return addValue(mixtape, change.Path, change.Value)
+	case "remove":
+		return removeValue(mixtape, change.Path)
+	case "replace":
+		return replaceValue(mixtape, change.Path, change.Value)
+	case "move":
+		return moveValue(mixtape, change.From, change.Path)
+	case "copy":
+		return copyValue(mixtape, change.From, change.Path)
+	case "test":
+		return testValue(mixtape, change.Path, change.Value)
+	}
+
+	return resources.NewError(resources.CodeUnsupported, "Unsupported operation %v.", change.Op)
+}
+
+// The move operation removes the value at the from location and adds it to the target location.
+// A location cannot be moved into one of its children. A song that is moved within a playlist
+// keeps the playlist invariants while it is moved, and a user, song or playlist that is moved
+// to the end of its collection keeps its playlists, whatever the reference policy.
+func moveValue(mixtape *resources.MixTape, from, path string) error {
+	//
+	// A value moved to its own location is not changed, it must exist.
+	//
+	if from == path {
+		_, err := getValue(mixtape, from)
+		return err
+	}
+
+	if strings.HasPrefix(path, from+"/") {
+		return resources.NewError(resources.CodeInvalidPath, "Cannot move %v into one of its children.", from)
+	}
+
+	source, err := resolve(mixtape, from)
+	if err != nil {
+		return err
+	}
+
+	target, err := resolve(mixtape, path)
+	if err != nil {
+		return err
+	}
+
+	//
+	// A song moved in its playlist, or a user, song or playlist moved to the end of its
+	// collection, is only reordered, so its references are kept.
+	//
+	switch source := source.(type) {
+	case *playlistSongNode:
+		if targetSong, ok := target.(*playlistSongNode); ok && source.id == targetSong.id {
+			return source.moveTo(targetSong)
+		}
+	case *userNode:
+		if end, ok := target.(*userNode); ok && end.id == "-" && source.id != "-" {
+			return mixtape.MoveUserToEnd(source.id)
+		}
+	case *songNode:
+		if end, ok := target.(*songNode); ok && end.id == "-" && source.id != "-" {
+			return mixtape.MoveSongToEnd(source.id)
+		}
+	case *playlistNode:
+		if end, ok := target.(*playlistNode); ok && end.id == "-" && source.id != "-" {
+			return mixtape.MovePlayListToEnd(source.id)
+		}
+	}
+
+	value, err := source.get()
+	if err != nil {
+		return err
+	}
+
+	err = source.remove()
+	if err != nil {
+		return err
+	}
+
+	return target.add(value)
+}
+
+// The copy operation adds the value at the from location to the target location.
+func copyValue(mixtape *resources.MixTape, from, path string) error {
+	value, err := getValue(mixtape, from)
+	if err != nil {
+		return err
+	}
+
+	return addValue(mixtape, path, value)
+}
+
+// The test operation succeeds when the value at the target location is equal to the
+// specified value.
+func testValue(mixtape *resources.MixTape, path string, expected interface{}) error {
+	actual, err := getValue(mixtape, path)
+	if err != nil {
+		return err
+	}
+
+	equal, err := jsonEqual(actual, expected)
+	if err != nil {
+		return err
+	}
+	if !equal {
+		return resources.NewError(resources.CodeTestFailed, "The value at %v is not equal to the test value.", path)
+	}
+
+	return nil
+}
+
+func getValue(mixtape *resources.MixTape, path string) (interface{}, error) {
+	node, err := resolve(mixtape, path)
+	if err != nil {
+		return nil, err
+	}
+	return node.get()
+}
+
+func addValue(mixtape *resources.MixTape, path string, value interface{}) error {
+	node, err := resolve(mixtape, path)
+	if err != nil {
+		return err
+	}
+	return node.add(value)
+}
+
+func removeValue(mixtape *resources.MixTape, path string) error {
+	node, err := resolve(mixtape, path)
+	if err != nil {
+		return err
+	}
+	return node.remove()
+}
+
+func replaceValue(mixtape *resources.MixTape, path string, value interface{}) error {
+	node, err := resolve(mixtape, path)
+	if err != nil {
+		return err
+	}
+	return node.replace(value)
+}
+
+func decodePlaylist(value interface{}) (*resources.PlayList, error) {
+	var playlist resources.PlayList
+	err := decodeValue("playlist", validation.PatchPlaylistSchema, value, &playlist)
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func decodeUser(value interface{}) (*resources.User, error) {
+	var user resources.User
+	err := decodeValue("user", validation.PatchUserSchema, value, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func decodeSong(value interface{}) (*resources.Song, error) {
+	var song resources.Song
+	err := decodeValue("song", validation.PatchSongSchema, value, &song)
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+// Validate a change value against the schema and unmarshal it
+func decodeValue(kind string, schema string, value interface{}, v interface{}) error {
+	if value == nil {
+		return resources.NewError(resources.CodeInvalidValue, "Missing %v value.", kind)
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return resources.NewError(resources.CodeInvalidValue, "Invalid %v value. %v", kind, err)
+	}
+
+	err = validation.Validate(schema, string(valueJSON))
+	if err != nil {
+		return resources.WrapError(resources.CodeInvalidValue, err, "Invalid %v value. %v", kind, err)
+	}
+
+	err = json.Unmarshal(valueJSON, v)
+	if err != nil {
+		return resources.NewError(resources.CodeInvalidValue, "Invalid %v value. %v", kind, err)
+	}
+
+	return nil
+}
+
+func decodeID(kind string, value interface{}) (string, error) {
+	if value == nil {
+		return "", resources.NewError(resources.CodeInvalidValue, "Missing %v ID value.", kind)
+	}
+	id, ok := value.(string)
+	if !ok {
+		return "", resources.NewError(resources.CodeInvalidValue, "Invalid %v ID value.", kind)
+	}
+	return id, nil
+}
+
+func decodeIDs(kind string, value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		if ids, ok := value.([]string); ok {
+			return append([]string{}, ids...), nil
+		}
+		return nil, resources.NewError(resources.CodeInvalidValue, "Invalid %v IDs value.", kind)
+	}
+
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		id, err := decodeID(kind, value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Compare two values for equality by their JSON representation
+func jsonEqual(a, b interface{}) (bool, error) {
+	var values [2]interface{}
+	for i, value := range []interface{}{a, b} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return false, err
+		}
+		err = json.Unmarshal(data, &values[i])
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return reflect.DeepEqual(values[0], values[1]), nil
+}
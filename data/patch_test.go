@@ -0,0 +1,294 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"highspot/resources"
+	"reflect"
+	"testing"
+)
+
+// A mixtape of two users, three songs and two playlists
+const patchTestMixTape = `{
+  "users": [
+    {"id": "1", "name": "Albin Jaye"},
+    {"id": "2", "name": "Dipika Crescentia"}
+  ],
+  "playlists": [
+    {"id": "1", "user_id": "1", "song_ids": ["1", "2"]},
+    {"id": "2", "user_id": "2", "song_ids": ["1"]}
+  ],
+  "songs": [
+    {"id": "1", "artist": "Camila Cabello", "title": "Never Be the Same"},
+    {"id": "2", "artist": "Zedd", "title": "The Middle"},
+    {"id": "3", "artist": "The Weeknd", "title": "Pray For Me"}
+  ]
+}`
+
+func newPatchTestMixTape(t *testing.T) *resources.MixTape {
+	mixtape, err := DecodeMixTape([]byte(patchTestMixTape), resources.IngestStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mixtape
+}
+
+func TestOperations(t *testing.T) {
+	tests := []struct {
+		name   string
+		change string
+		// The error code of the change, or the value at the path after the change
+		code  resources.ErrorCode
+		path  string
+		value string
+	}{
+		// add
+		{"add a user", `{"op": "add", "path": "/users/-", "value": {"id": "3", "name": "Ana"}}`,
+			"", "/users", `[{"id": "1", "name": "Albin Jaye"}, {"id": "2", "name": "Dipika Crescentia"}, {"id": "3", "name": "Ana"}]`},
+		{"add a user with its ID", `{"op": "add", "path": "/users/3", "value": {"id": "3", "name": "Ana"}}`,
+			"", "/users/3/name", `"Ana"`},
+		{"add an existing user", `{"op": "add", "path": "/users/-", "value": {"id": "1", "name": "Ana"}}`,
+			resources.CodeDuplicate, "", ""},
+		{"add an existing field", `{"op": "add", "path": "/users/1/name", "value": "Albin"}`,
+			"", "/users/1/name", `"Albin"`},
+		{"add a song at a position", `{"op": "add", "path": "/playlists/1/song_ids/0", "value": "3"}`,
+			"", "/playlists/1/song_ids", `["3", "1", "2"]`},
+		{"add a song at the end", `{"op": "add", "path": "/playlists/1/song_ids/-", "value": "3"}`,
+			"", "/playlists/1/song_ids", `["1", "2", "3"]`},
+		{"add a song twice", `{"op": "add", "path": "/playlists/1/song_ids/-", "value": "1"}`,
+			resources.CodeDuplicate, "", ""},
+		{"add a song past the end", `{"op": "add", "path": "/playlists/1/song_ids/3", "value": "3"}`,
+			resources.CodeOutOfRange, "", ""},
+
+		// remove
+		{"remove a playlist", `{"op": "remove", "path": "/playlists/2"}`,
+			"", "/playlists", `[{"id": "1", "user_id": "1", "song_ids": ["1", "2"]}]`},
+		{"remove a song of a playlist", `{"op": "remove", "path": "/playlists/1/song_ids/1"}`,
+			"", "/playlists/1/song_ids", `["1"]`},
+		{"remove a missing user", `{"op": "remove", "path": "/users/9"}`,
+			resources.CodeNotFound, "", ""},
+		{"remove a referenced song", `{"op": "remove", "path": "/songs/1"}`,
+			resources.CodeReferenced, "", ""},
+		{"remove a signed index", `{"op": "remove", "path": "/playlists/1/song_ids/+1"}`,
+			resources.CodeInvalidPath, "", ""},
+		{"remove a field", `{"op": "remove", "path": "/users/1/name"}`,
+			resources.CodeUnsupported, "", ""},
+
+		// replace
+		{"replace a song field", `{"op": "replace", "path": "/songs/1/title", "value": "Havana"}`,
+			"", "/songs/1", `{"id": "1", "artist": "Camila Cabello", "title": "Havana"}`},
+		{"replace a user with another ID", `{"op": "replace", "path": "/users/1", "value": {"id": "2", "name": "Ana"}}`,
+			resources.CodeInvalidValue, "", ""},
+		{"replace the songs of a playlist", `{"op": "replace", "path": "/playlists/1/song_ids", "value": ["3"]}`,
+			"", "/playlists/1", `{"id": "1", "user_id": "1", "song_ids": ["3"]}`},
+		{"replace a missing song", `{"op": "replace", "path": "/songs/9/title", "value": "Havana"}`,
+			resources.CodeNotFound, "", ""},
+
+		// move
+		{"move a song in its playlist", `{"op": "move", "from": "/playlists/1/song_ids/0", "path": "/playlists/1/song_ids/-"}`,
+			"", "/playlists/1/song_ids", `["2", "1"]`},
+		{"move a song to another playlist", `{"op": "move", "from": "/playlists/1/song_ids/1", "path": "/playlists/2/song_ids/-"}`,
+			"", "/playlists", `[{"id": "1", "user_id": "1", "song_ids": ["1"]}, {"id": "2", "user_id": "2", "song_ids": ["1", "2"]}]`},
+		{"move a referenced user to the end", `{"op": "move", "from": "/users/1", "path": "/users/-"}`,
+			"", "/users", `[{"id": "2", "name": "Dipika Crescentia"}, {"id": "1", "name": "Albin Jaye"}]`},
+		{"move a user to its location", `{"op": "move", "from": "/users/1", "path": "/users/1"}`,
+			"", "/users/1", `{"id": "1", "name": "Albin Jaye"}`},
+		{"move a missing user to its location", `{"op": "move", "from": "/users/9", "path": "/users/9"}`,
+			resources.CodeNotFound, "", ""},
+		{"move a playlist into itself", `{"op": "move", "from": "/playlists/1", "path": "/playlists/1/song_ids/0"}`,
+			resources.CodeInvalidPath, "", ""},
+
+		// copy
+		{"copy a field", `{"op": "copy", "from": "/users/1/name", "path": "/users/2/name"}`,
+			"", "/users/2/name", `"Albin Jaye"`},
+		{"copy a song to a playlist", `{"op": "copy", "from": "/playlists/1/song_ids/1", "path": "/playlists/2/song_ids/0"}`,
+			"", "/playlists/2/song_ids", `["2", "1"]`},
+		{"copy a playlist to its own ID", `{"op": "copy", "from": "/playlists/1", "path": "/playlists/-"}`,
+			resources.CodeDuplicate, "", ""},
+
+		// test
+		{"test a field", `{"op": "test", "path": "/users/1/name", "value": "Albin Jaye"}`,
+			"", "/users/1/name", `"Albin Jaye"`},
+		{"test the songs of a playlist", `{"op": "test", "path": "/playlists/1/song_ids", "value": ["1", "2"]}`,
+			"", "/playlists/1/song_ids", `["1", "2"]`},
+		{"test another value", `{"op": "test", "path": "/users/1/name", "value": "Albin"}`,
+			resources.CodeTestFailed, "", ""},
+	}
+
+	for _, test := range tests {
+		changes, err := DecodeChanges([]byte("[" + test.change + "]"))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		mixtape := newPatchTestMixTape(t)
+		err = applyChange(mixtape, &changes[0])
+		if len(test.code) != 0 {
+			if resources.Code(err) != test.code {
+				t.Errorf("%v: expected the code %v, got %v", test.name, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		var expected interface{}
+		err = json.Unmarshal([]byte(test.value), &expected)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		actual, err := getValue(mixtape, test.path)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if equal, _ := jsonEqual(actual, expected); !equal {
+			actualJSON, _ := json.Marshal(actual)
+			t.Errorf("%v: expected %v at %v, got %s", test.name, test.value, test.path, actualJSON)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	for _, name := range []string{"transactional", "best-effort"} {
+		if _, err := ParseApplyMode(name); err != nil {
+			t.Errorf("apply mode %v: %v", name, err)
+		}
+	}
+	for _, name := range []string{"detect", "json-patch", "merge-patch"} {
+		if _, err := ParseChangesFormat(name); err != nil {
+			t.Errorf("changes format %v: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "best_effort", "Transactional"} {
+		if _, err := ParseApplyMode(name); resources.Code(err) != resources.CodeInvalidValue {
+			t.Errorf("apply mode %q: expected an invalid value error, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "patch", "json"} {
+		if _, err := ParseChangesFormat(name); resources.Code(err) != resources.CodeInvalidValue {
+			t.Errorf("changes format %q: expected an invalid value error, got %v", name, err)
+		}
+	}
+}
+
+// The statuses and codes of the results
+func resultStatuses(results []ChangeResult) []string {
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, fmt.Sprintf("%v %v", result.Status, result.Code))
+	}
+	return statuses
+}
+
+func TestApplyChangesModes(t *testing.T) {
+	changes, err := DecodeChanges([]byte(`[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "remove", "path": "/playlists/2"},
+		{"op": "add", "path": "/playlists/1/song_ids/-", "value": "1"},
+		{"op": "replace", "path": "/songs/1/title", "value": "Havana"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	// In transactional mode the failed change rolls back the changes before it, and the
+	// changes after it are not applied
+	//
+
+	mixtape := newPatchTestMixTape(t)
+	before := documentInInsertionOrder(t, mixtape)
+
+	results, err := ApplyChanges(mixtape, changes, Transactional)
+	var changeErr *ChangeError
+	if !errors.As(err, &changeErr) || changeErr.Index != 2 || resources.Code(err) != resources.CodeDuplicate {
+		t.Fatalf("expected the duplicate error of change 2, got %v", err)
+	}
+	expected := []string{"skipped rolled_back", "skipped rolled_back", "failed duplicate", "skipped aborted"}
+	if actual := resultStatuses(results); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("transactional: expected the results %v, got %v", expected, actual)
+	}
+	if after := documentInInsertionOrder(t, mixtape); after != before {
+		t.Errorf("transactional: expected the mixtape to be unchanged, got %v", after)
+	}
+
+	//
+	// In best-effort mode the failed change is skipped
+	//
+
+	mixtape = newPatchTestMixTape(t)
+	results, err = ApplyChanges(mixtape, changes, BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"applied ", "applied ", "failed duplicate", "applied "}
+	if actual := resultStatuses(results); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("best-effort: expected the results %v, got %v", expected, actual)
+	}
+
+	for path, value := range map[string]string{
+		"/users/1/name":         `"Albin"`,
+		"/playlists/1/song_ids": `["1", "2"]`,
+		"/songs/1/title":        `"Havana"`,
+	} {
+		actual, err := getValue(mixtape, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expectedValue interface{}
+		json.Unmarshal([]byte(value), &expectedValue)
+		if equal, _ := jsonEqual(actual, expectedValue); !equal {
+			t.Errorf("best-effort: expected %v at %v, got %v", value, path, actual)
+		}
+	}
+	if _, err = getValue(mixtape, "/playlists/2"); resources.Code(err) != resources.CodeNotFound {
+		t.Errorf("best-effort: expected playlist 2 to be removed, got %v", err)
+	}
+}
+
+func TestApplyChangesBestEffort(t *testing.T) {
+	//
+	// A move that removes the song, then fails to add it, leaves the song in its playlist, and
+	// a failed test aborts the patch
+	//
+	changes, err := DecodeChanges([]byte(`[
+		{"op": "move", "from": "/playlists/1/song_ids/0", "path": "/playlists/2/song_ids/0"},
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "test", "path": "/users/2/name", "value": "Dipika"},
+		{"op": "replace", "path": "/users/2/name", "value": "Dipika"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mixtape := newPatchTestMixTape(t)
+	results, err := ApplyChanges(mixtape, changes, BestEffort)
+	var changeErr *ChangeError
+	if !errors.As(err, &changeErr) || changeErr.Index != 2 || resources.Code(err) != resources.CodeTestFailed {
+		t.Fatalf("expected the failed test of change 2, got %v", err)
+	}
+	expected := []string{"failed duplicate", "applied ", "failed test_failed", "skipped aborted"}
+	if actual := resultStatuses(results); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected the results %v, got %v", expected, actual)
+	}
+
+	playlist, err := mixtape.GetPlayList("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(playlist.SongIDs, []string{"1", "2"}) {
+		t.Errorf("expected the songs of playlist 1 to be unchanged, got %v", playlist.SongIDs)
+	}
+	user, err := mixtape.GetUser("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Dipika Crescentia" {
+		t.Errorf("expected the aborted change not to be applied, got %v", user.Name)
+	}
+}
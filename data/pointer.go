@@ -0,0 +1,652 @@
+package data
+
+import (
+	"highspot/resources"
+	"strconv"
+	"strings"
+)
+
+//
+// A JSON pointer (RFC 6901) is resolved to a node in the mixtape document. The users, songs
+// and playlists collections are keyed by ID rather than by array position, for example
+// /playlists/3 is the playlist with ID 3. The "-" token refers to the end of a collection.
+//
+// The addressable nodes are:
+//
+//   ""                                  the mixtape document
+//   /users, /songs, /playlists          a collection
+//   /users/-, /songs/-, /playlists/-    the end of a collection
+//   /users/{id}                         a user
+//   /users/{id}/{field}                 a user field
+//   /songs/{id}                         a song
+//   /songs/{id}/{field}                 a song field
+//   /playlists/{id}                     a playlist
+//   /playlists/{id}/{field}             a playlist field
+//   /playlists/{id}/song_ids/{index}    a song position in a playlist
+//   /playlists/{id}/song_ids/-          the end of a playlist
+//
+
+// A node is an addressable location in the mixtape document
+type node interface {
+	get() (interface{}, error)
+	add(value interface{}) error
+	remove() error
+	replace(value interface{}) error
+}
+
+// Parse a JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if len(pointer) == 0 {
+		return []string{}, nil
+	}
+
+	if pointer[0] != '/' {
+		return nil, resources.NewError(resources.CodeInvalidPath, "The path %v is not a valid JSON pointer.", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, resources.NewError(resources.CodeInvalidPath, "The path %v has an invalid escape sequence.", pointer)
+			}
+		}
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// Resolve a JSON pointer to a node in the mixtape document
+func resolve(mixtape *resources.MixTape, pointer string) (node, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return &documentNode{location{pointer}, mixtape}, nil
+	}
+
+	loc := location{pointer}
+	switch tokens[0] {
+	case "users":
+		switch len(tokens) {
+		case 1:
+			return &usersNode{loc, mixtape}, nil
+		case 2:
+			return &userNode{loc, mixtape, tokens[1]}, nil
+		case 3:
+			return &userFieldNode{loc, mixtape, tokens[1], tokens[2]}, nil
+		}
+	case "songs":
+		switch len(tokens) {
+		case 1:
+			return &songsNode{loc, mixtape}, nil
+		case 2:
+			return &songNode{loc, mixtape, tokens[1]}, nil
+		case 3:
+			return &songFieldNode{loc, mixtape, tokens[1], tokens[2]}, nil
+		}
+	case "playlists":
+		switch len(tokens) {
+		case 1:
+			return &playlistsNode{loc, mixtape}, nil
+		case 2:
+			return &playlistNode{loc, mixtape, tokens[1]}, nil
+		case 3:
+			return &playlistFieldNode{loc, mixtape, tokens[1], tokens[2]}, nil
+		case 4:
+			if tokens[2] == "song_ids" {
+				return &playlistSongNode{loc, mixtape, tokens[1], tokens[3]}, nil
+			}
+		}
+	}
+
+	return nil, resources.NewError(resources.CodeInvalidPath, "The path %v does not exist.", pointer)
+}
+
+//
+// The location is embedded in every node, it rejects the operations a node does not support.
+//
+
+type location struct {
+	path string
+}
+
+func (l location) get() (interface{}, error) {
+	return nil, resources.NewError(resources.CodeUnsupported, "Cannot get the value at %v.", l.path)
+}
+
+func (l location) add(value interface{}) error {
+	return resources.NewError(resources.CodeUnsupported, "Cannot add a value at %v.", l.path)
+}
+
+func (l location) remove() error {
+	return resources.NewError(resources.CodeUnsupported, "Cannot remove the value at %v.", l.path)
+}
+
+func (l location) replace(value interface{}) error {
+	return resources.NewError(resources.CodeUnsupported, "Cannot replace the value at %v.", l.path)
+}
+
+// The mixtape document
+type documentNode struct {
+	location
+	mixtape *resources.MixTape
+}
+
+func (n *documentNode) get() (interface{}, error) {
+	return n.mixtape, nil
+}
+
+// The users collection
+type usersNode struct {
+	location
+	mixtape *resources.MixTape
+}
+
+func (n *usersNode) get() (interface{}, error) {
+	users, err := n.mixtape.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// A user in the users collection, the "-" ID is the end of the collection
+type userNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+}
+
+func (n *userNode) get() (interface{}, error) {
+	if n.id == "-" {
+		return n.location.get()
+	}
+	return n.mixtape.GetUser(n.id)
+}
+
+func (n *userNode) add(value interface{}) error {
+	user, err := decodeUser(value)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Add a new user to the end of the collection.
+	//
+	if n.id == "-" {
+		return n.mixtape.AddUser(user)
+	}
+
+	//
+	// Add a user with the specified ID, an existing user is replaced.
+	//
+	if user.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "User ID %v does not match the path %v.", user.ID, n.path)
+	}
+	if _, err := n.mixtape.GetUser(user.ID); err == nil {
+		return n.mixtape.ReplaceUser(user)
+	}
+	return n.mixtape.AddUser(user)
+}
+
+func (n *userNode) remove() error {
+	if n.id == "-" {
+		return n.location.remove()
+	}
+	return n.mixtape.RemoveUser(n.id)
+}
+
+func (n *userNode) replace(value interface{}) error {
+	if n.id == "-" {
+		return n.location.replace(value)
+	}
+
+	user, err := decodeUser(value)
+	if err != nil {
+		return err
+	}
+	if user.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "User ID %v does not match the path %v.", user.ID, n.path)
+	}
+	return n.mixtape.ReplaceUser(user)
+}
+
+// A field of a user
+type userFieldNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+	field   string
+}
+
+func (n *userFieldNode) get() (interface{}, error) {
+	user, err := n.mixtape.GetUser(n.id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.field {
+	case "id":
+		return user.ID, nil
+	case "name":
+		return user.Name, nil
+	}
+
+	return nil, resources.NewError(resources.CodeInvalidPath, "The path %v does not exist.", n.path)
+}
+
+// Adding an existing member replaces it (RFC 6902 4.1), the fields of a user always exist
+func (n *userFieldNode) add(value interface{}) error {
+	return n.replace(value)
+}
+
+func (n *userFieldNode) replace(value interface{}) error {
+	if n.field != "name" {
+		return n.location.replace(value)
+	}
+
+	user, err := n.mixtape.GetUser(n.id)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Rename the user, the renamed user is validated against the user schema.
+	//
+	renamed, err := decodeUser(map[string]interface{}{"id": user.ID, "name": value})
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.ReplaceUser(renamed)
+}
+
+// The songs collection
+type songsNode struct {
+	location
+	mixtape *resources.MixTape
+}
+
+func (n *songsNode) get() (interface{}, error) {
+	songs, err := n.mixtape.GetSongs()
+	if err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+// A song in the songs collection, the "-" ID is the end of the collection
+type songNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+}
+
+func (n *songNode) get() (interface{}, error) {
+	if n.id == "-" {
+		return n.location.get()
+	}
+	return n.mixtape.GetSong(n.id)
+}
+
+func (n *songNode) add(value interface{}) error {
+	song, err := decodeSong(value)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Add a new song to the end of the collection.
+	//
+	if n.id == "-" {
+		return n.mixtape.AddSong(song)
+	}
+
+	//
+	// Add a song with the specified ID, an existing song is replaced.
+	//
+	if song.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "Song ID %v does not match the path %v.", song.ID, n.path)
+	}
+	if _, err := n.mixtape.GetSong(song.ID); err == nil {
+		return n.mixtape.ReplaceSong(song)
+	}
+	return n.mixtape.AddSong(song)
+}
+
+func (n *songNode) remove() error {
+	if n.id == "-" {
+		return n.location.remove()
+	}
+	return n.mixtape.RemoveSong(n.id)
+}
+
+func (n *songNode) replace(value interface{}) error {
+	if n.id == "-" {
+		return n.location.replace(value)
+	}
+
+	song, err := decodeSong(value)
+	if err != nil {
+		return err
+	}
+	if song.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "Song ID %v does not match the path %v.", song.ID, n.path)
+	}
+	return n.mixtape.ReplaceSong(song)
+}
+
+// A field of a song
+type songFieldNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+	field   string
+}
+
+func (n *songFieldNode) get() (interface{}, error) {
+	song, err := n.mixtape.GetSong(n.id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.field {
+	case "id":
+		return song.ID, nil
+	case "artist":
+		return song.Artist, nil
+	case "title":
+		return song.Title, nil
+	}
+
+	return nil, resources.NewError(resources.CodeInvalidPath, "The path %v does not exist.", n.path)
+}
+
+// Adding an existing member replaces it (RFC 6902 4.1), the fields of a song always exist
+func (n *songFieldNode) add(value interface{}) error {
+	return n.replace(value)
+}
+
+func (n *songFieldNode) replace(value interface{}) error {
+	song, err := n.mixtape.GetSong(n.id)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Edit the song, the edited song is validated against the song schema.
+	//
+	edited := map[string]interface{}{"id": song.ID, "artist": song.Artist, "title": song.Title}
+	switch n.field {
+	case "artist", "title":
+		edited[n.field] = value
+	default:
+		return n.location.replace(value)
+	}
+
+	editedSong, err := decodeSong(edited)
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.ReplaceSong(editedSong)
+}
+
+// The playlists collection
+type playlistsNode struct {
+	location
+	mixtape *resources.MixTape
+}
+
+func (n *playlistsNode) get() (interface{}, error) {
+	playlists, err := n.mixtape.GetPlayLists()
+	if err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// A playlist in the playlists collection, the "-" ID is the end of the collection
+type playlistNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+}
+
+func (n *playlistNode) get() (interface{}, error) {
+	if n.id == "-" {
+		return n.location.get()
+	}
+	return n.mixtape.GetPlayList(n.id)
+}
+
+func (n *playlistNode) add(value interface{}) error {
+	playlist, err := decodePlaylist(value)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Add a new playlist to the end of the collection.
+	//
+	if n.id == "-" {
+		return n.mixtape.AddPlayList(playlist)
+	}
+
+	//
+	// Add a playlist with the specified ID, an existing playlist is replaced.
+	//
+	if playlist.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "Playlist ID %v does not match the path %v.", playlist.ID, n.path)
+	}
+	if _, err := n.mixtape.GetPlayList(playlist.ID); err == nil {
+		return n.mixtape.ReplacePlayList(playlist)
+	}
+	return n.mixtape.AddPlayList(playlist)
+}
+
+func (n *playlistNode) remove() error {
+	if n.id == "-" {
+		return n.location.remove()
+	}
+	return n.mixtape.RemovePlayList(n.id)
+}
+
+func (n *playlistNode) replace(value interface{}) error {
+	if n.id == "-" {
+		return n.location.replace(value)
+	}
+
+	playlist, err := decodePlaylist(value)
+	if err != nil {
+		return err
+	}
+	if playlist.ID != n.id {
+		return resources.NewError(resources.CodeInvalidValue, "Playlist ID %v does not match the path %v.", playlist.ID, n.path)
+	}
+	return n.mixtape.ReplacePlayList(playlist)
+}
+
+// A field of a playlist
+type playlistFieldNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+	field   string
+}
+
+func (n *playlistFieldNode) get() (interface{}, error) {
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.field {
+	case "id":
+		return playlist.ID, nil
+	case "user_id":
+		return playlist.UserID, nil
+	case "song_ids":
+		return playlist.SongIDs, nil
+	}
+
+	return nil, resources.NewError(resources.CodeInvalidPath, "The path %v does not exist.", n.path)
+}
+
+// Adding an existing member replaces it (RFC 6902 4.1), the fields of a playlist always exist
+func (n *playlistFieldNode) add(value interface{}) error {
+	return n.replace(value)
+}
+
+func (n *playlistFieldNode) replace(value interface{}) error {
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return err
+	}
+
+	switch n.field {
+	case "user_id":
+		playlist.UserID, err = decodeID("user", value)
+	case "song_ids":
+		playlist.SongIDs, err = decodeIDs("song", value)
+	default:
+		return n.location.replace(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.ReplacePlayList(playlist)
+}
+
+// A song position in a playlist, the "-" index is the end of the playlist
+type playlistSongNode struct {
+	location
+	mixtape *resources.MixTape
+	id      string
+	index   string
+}
+
+func (n *playlistSongNode) get() (interface{}, error) {
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := parseIndex(n.index, len(playlist.SongIDs)-1)
+	if err != nil {
+		return nil, err
+	}
+
+	return playlist.SongIDs[index], nil
+}
+
+func (n *playlistSongNode) add(value interface{}) error {
+	songID, err := decodeID("song", value)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Add an existing song to the end of an existing playlist
+	//
+	if n.index == "-" {
+		return n.mixtape.AddSongToPlayList(n.id, songID)
+	}
+
+	//
+	// Insert an existing song in an existing playlist, the index can be the end of the playlist
+	//
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return err
+	}
+
+	index, err := parseIndex(n.index, len(playlist.SongIDs))
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.InsertSongInPlayList(n.id, index, songID)
+}
+
+func (n *playlistSongNode) remove() error {
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return err
+	}
+
+	index, err := parseIndex(n.index, len(playlist.SongIDs)-1)
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.RemoveSongFromPlayList(n.id, index)
+}
+
+func (n *playlistSongNode) replace(value interface{}) error {
+	songID, err := decodeID("song", value)
+	if err != nil {
+		return err
+	}
+
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return err
+	}
+
+	index, err := parseIndex(n.index, len(playlist.SongIDs)-1)
+	if err != nil {
+		return err
+	}
+
+	return n.mixtape.ReplaceSongInPlayList(n.id, index, songID)
+}
+
+// Move the song to another position in the same playlist, the "-" index is the last position
+func (n *playlistSongNode) moveTo(target *playlistSongNode) error {
+	playlist, err := n.mixtape.GetPlayList(n.id)
+	if err != nil {
+		return err
+	}
+
+	last := len(playlist.SongIDs) - 1
+
+	from, err := parseIndex(n.index, last)
+	if err != nil {
+		return err
+	}
+
+	to := last
+	if target.index != "-" {
+		to, err = parseIndex(target.index, last)
+		if err != nil {
+			return err
+		}
+	}
+
+	return n.mixtape.MoveSongInPlayList(n.id, from, to)
+}
+
+// Parse an array index token, the index must be in the range 0 to max. An index is 0 or digits
+// without a leading zero (RFC 6901), so a sign or a "-" is invalid.
+func parseIndex(token string, max int) (int, error) {
+	if len(token) == 0 || len(strings.Trim(token, "0123456789")) != 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, resources.NewError(resources.CodeInvalidPath, "The index %v is invalid.", token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, resources.NewError(resources.CodeInvalidPath, "The index %v is invalid.", token)
+	}
+
+	if index > max {
+		return 0, resources.NewError(resources.CodeOutOfRange, "The index %v is out of bounds.", token)
+	}
+
+	return index, nil
+}
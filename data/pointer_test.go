@@ -0,0 +1,120 @@
+package data
+
+import (
+	"highspot/resources"
+	"reflect"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		tokens  []string
+		valid   bool
+	}{
+		{"", []string{}, true},
+		{"/", []string{""}, true},
+		{"/users", []string{"users"}, true},
+		{"/users/-", []string{"users", "-"}, true},
+		{"/playlists/1/song_ids/0", []string{"playlists", "1", "song_ids", "0"}, true},
+		// ~1 is a / and ~0 is a ~, ~01 is ~1 rather than /
+		{"/a~1b", []string{"a/b"}, true},
+		{"/a~0b", []string{"a~b"}, true},
+		{"/~01", []string{"~1"}, true},
+		{"/~10", []string{"/0"}, true},
+		{"/a//b", []string{"a", "", "b"}, true},
+		{"users", nil, false},
+		{"/a~", nil, false},
+		{"/a~2b", nil, false},
+		{"/~/", nil, false},
+	}
+
+	for _, test := range tests {
+		tokens, err := parsePointer(test.pointer)
+		if !test.valid {
+			if resources.Code(err) != resources.CodeInvalidPath {
+				t.Errorf("%q: expected an invalid path error, got %v %v", test.pointer, tokens, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("%q: expected the tokens %q, got %q", test.pointer, test.tokens, tokens)
+		}
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		token string
+		index int
+		code  resources.ErrorCode
+	}{
+		{"0", 0, ""},
+		{"1", 1, ""},
+		{"10", 10, ""},
+		{"11", 0, resources.CodeOutOfRange},
+		{"01", 0, resources.CodeInvalidPath},
+		{"00", 0, resources.CodeInvalidPath},
+		{"+1", 0, resources.CodeInvalidPath},
+		{"-1", 0, resources.CodeInvalidPath},
+		{"-", 0, resources.CodeInvalidPath},
+		{"", 0, resources.CodeInvalidPath},
+		{" 1", 0, resources.CodeInvalidPath},
+		{"1e1", 0, resources.CodeInvalidPath},
+		{"0x1", 0, resources.CodeInvalidPath},
+		{"99999999999999999999", 0, resources.CodeInvalidPath},
+	}
+
+	for _, test := range tests {
+		index, err := parseIndex(test.token, 10)
+		if resources.Code(err) != test.code && !(err == nil && len(test.code) == 0) {
+			t.Errorf("%q: expected the code %q, got %v", test.token, test.code, err)
+			continue
+		}
+		if err == nil && index != test.index {
+			t.Errorf("%q: expected the index %v, got %v", test.token, test.index, index)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	mixtape := resources.NewMixTape()
+
+	tests := []struct {
+		pointer  string
+		expected node
+	}{
+		{"", &documentNode{location{""}, mixtape}},
+		{"/users", &usersNode{location{"/users"}, mixtape}},
+		{"/users/-", &userNode{location{"/users/-"}, mixtape, "-"}},
+		{"/users/1/name", &userFieldNode{location{"/users/1/name"}, mixtape, "1", "name"}},
+		{"/songs/a~1b", &songNode{location{"/songs/a~1b"}, mixtape, "a/b"}},
+		{"/songs/2/title", &songFieldNode{location{"/songs/2/title"}, mixtape, "2", "title"}},
+		{"/playlists/3", &playlistNode{location{"/playlists/3"}, mixtape, "3"}},
+		{"/playlists/3/user_id", &playlistFieldNode{location{"/playlists/3/user_id"}, mixtape, "3", "user_id"}},
+		{"/playlists/3/song_ids/-", &playlistSongNode{location{"/playlists/3/song_ids/-"}, mixtape, "3", "-"}},
+		{"/playlists/3/song_ids/+1", &playlistSongNode{location{"/playlists/3/song_ids/+1"}, mixtape, "3", "+1"}},
+	}
+
+	for _, test := range tests {
+		actual, err := resolve(mixtape, test.pointer)
+		if err != nil {
+			t.Errorf("%q: %v", test.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %#v, got %#v", test.pointer, test.expected, actual)
+		}
+	}
+
+	for _, pointer := range []string{"/artists", "/users/1/name/first", "/playlists/3/songs/0", "/playlists/3/song_ids/0/x"} {
+		_, err := resolve(mixtape, pointer)
+		if resources.Code(err) != resources.CodeInvalidPath {
+			t.Errorf("%q: expected an invalid path error, got %v", pointer, err)
+		}
+	}
+}
@@ -1,5 +1,14 @@
 package data
 
+import (
+	"io"
+)
+
 type Reader interface {
 	Read() ([]byte, error)
 }
+
+// A stream reader opens the input to read it incrementally, for inputs that do not fit in memory
+type StreamReader interface {
+	Open() (io.ReadCloser, error)
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"highspot/data/validation"
+	"highspot/resources"
+)
+
+// The status of a change in the report
+type ChangeStatus string
+
+const (
+	// The change was applied
+	Applied ChangeStatus = "applied"
+	// The change was not applied because another change failed
+	Skipped ChangeStatus = "skipped"
+	// The change could not be applied
+	Failed ChangeStatus = "failed"
+)
+
+// The result of applying a change
+type ChangeResult struct {
+	Index   int                 `json:"index"`
+	Op      string              `json:"op"`
+	Path    string              `json:"path"`
+	Status  ChangeStatus        `json:"status"`
+	Code    resources.ErrorCode `json:"code,omitempty"`
+	Message string              `json:"message,omitempty"`
+	// The schema violations of an invalid change value
+	Violations []validation.Violation `json:"violations,omitempty"`
+}
+
+// The report produced by the ingester, with the issues found in the input playlists
+// and one result per change
+type Report struct {
+	Ingestion []resources.Issue `json:"ingestion"`
+	Changes   []ChangeResult    `json:"changes"`
+}
+
+func newChangeResults(changes []resources.Change) []ChangeResult {
+	results := make([]ChangeResult, len(changes))
+	for index, change := range changes {
+		results[index] = ChangeResult{
+			Index: index,
+			Op:    change.Op,
+			Path:  change.Path,
+		}
+	}
+	return results
+}
+
+func (r *ChangeResult) setApplied() {
+	r.Status = Applied
+}
+
+func (r *ChangeResult) setFailed(err error) {
+	r.Status = Failed
+	r.Code = resources.Code(err)
+	r.Message = err.Error()
+	r.Violations = validation.Violations(err)
+	for i := range r.Violations {
+		index := r.Index
+		r.Violations[i].Index = &index
+	}
+}
+
+func (r *ChangeResult) setSkipped(code resources.ErrorCode, message string) {
+	r.Status = Skipped
+	r.Code = code
+	r.Message = message
+}
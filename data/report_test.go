@@ -0,0 +1,71 @@
+package data
+
+import (
+	"encoding/json"
+	"highspot/resources"
+	"reflect"
+	"testing"
+)
+
+func TestChangeResults(t *testing.T) {
+	changes := []resources.Change{
+		{Op: "replace", Path: "/users/1/name", Value: "Albin"},
+		{Op: "remove", Path: "/songs/9"},
+		{Op: "add", Path: "/songs/-"},
+	}
+
+	results := newChangeResults(changes)
+	results[0].setApplied()
+	results[1].setFailed(resources.NewError(resources.CodeNotFound, "The song ID 9 does not exist."))
+	results[2].setSkipped(resources.CodeAborted, "Not applied, change 1 failed.")
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[` +
+		`{"index":0,"op":"replace","path":"/users/1/name","status":"applied"},` +
+		`{"index":1,"op":"remove","path":"/songs/9","status":"failed","code":"not_found","message":"The song ID 9 does not exist."},` +
+		`{"index":2,"op":"add","path":"/songs/-","status":"skipped","code":"aborted","message":"Not applied, change 1 failed."}` +
+		`]`
+	if string(data) != expected {
+		t.Errorf("expected the results\n%v\ngot\n%s", expected, data)
+	}
+}
+
+func TestChangeResultOfAnErrorWithoutACode(t *testing.T) {
+	results := newChangeResults([]resources.Change{{Op: "test", Path: "/users"}})
+	results[0].setFailed(json.Unmarshal([]byte("{"), &struct{}{}))
+
+	if results[0].Status != Failed || results[0].Code != resources.CodeError || len(results[0].Message) == 0 {
+		t.Errorf("expected a failed result with the error code, got %+v", results[0])
+	}
+}
+
+func TestChangeResultViolations(t *testing.T) {
+	changes, err := DecodeChanges([]byte(`[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "add", "path": "/users/-", "value": {"id": "3", "title": "Ana"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, _ := ApplyChanges(newPatchTestMixTape(t), changes, BestEffort)
+
+	// The violations of the invalid value have the index of the change
+	violations := results[1].Violations
+	if results[1].Code != resources.CodeInvalidValue || len(violations) != 2 {
+		t.Fatalf("expected the violations of the user value, got %+v", results[1])
+	}
+	for _, violation := range violations {
+		if violation.Index == nil || *violation.Index != 1 {
+			t.Errorf("expected the index 1 of the violation %v", violation)
+		}
+	}
+	keywords := []string{violations[0].Keyword, violations[1].Keyword}
+	if !reflect.DeepEqual(keywords, []string{"additionalProperties", "required"}) {
+		t.Errorf("expected the additionalProperties and required violations, got %v", violations)
+	}
+}
@@ -0,0 +1,351 @@
+// Package sqlite is a SQLite storage of the users, songs and playlists of a mixtape, so the
+// mixtape can be queried with SQL.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"highspot/resources"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+//
+// The songs of a playlist are the rows of the playlist_songs join table, with their position
+// in the playlist from 0. The foreign keys mirror the playlist invariants, the user and the
+// songs of a playlist exist, and a song is in a playlist once. The foreign keys are checked
+// when a transaction is committed, so a user or song can be removed before the playlists that
+// use it in the same transaction. The seq column is the insertion order of a table.
+//
+
+const schema = `
+CREATE TABLE users (
+	id   TEXT PRIMARY KEY,
+	seq  INTEGER NOT NULL UNIQUE,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE songs (
+	id     TEXT PRIMARY KEY,
+	seq    INTEGER NOT NULL UNIQUE,
+	artist TEXT NOT NULL,
+	title  TEXT NOT NULL
+);
+
+CREATE TABLE playlists (
+	id      TEXT PRIMARY KEY,
+	seq     INTEGER NOT NULL UNIQUE,
+	user_id TEXT NOT NULL REFERENCES users (id) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX playlists_user_id ON playlists (user_id);
+
+CREATE TABLE playlist_songs (
+	playlist_id TEXT NOT NULL REFERENCES playlists (id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	position    INTEGER NOT NULL CHECK (position >= 0),
+	song_id     TEXT NOT NULL REFERENCES songs (id) DEFERRABLE INITIALLY DEFERRED,
+	PRIMARY KEY (playlist_id, position),
+	UNIQUE (playlist_id, song_id)
+);
+
+CREATE INDEX playlist_songs_song_id ON playlist_songs (song_id);
+`
+
+// The statements of the database, or of a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// The SQLite storage. A change outside of Update is written in its own transaction.
+type Store struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// Create the database file with the mixtape tables, an existing file is replaced
+func Create(path string) (*Store, error) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	store, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = store.db.Exec(schema)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Open an existing database file created by Create
+func Open(path string) (*Store, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return open(path)
+}
+
+func open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) conn() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *Store) update(fn func(q querier) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.Update(func(storage resources.Storage) error {
+		return fn(storage.(*Store).tx)
+	})
+}
+
+// Run fn in a single transaction, the changes of fn are written atomically. The transaction
+// is rolled back when fn fails, or when a foreign key is violated.
+func (s *Store) Update(fn func(storage resources.Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(&Store{db: s.db, tx: tx})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Cannot commit the database transaction. %w", err)
+	}
+	return nil
+}
+
+func (s *Store) GetUser(userID string) (*resources.User, error) {
+	var user resources.User
+	err := s.conn().QueryRow(`SELECT id, name FROM users WHERE id = ?`, userID).Scan(&user.ID, &user.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *Store) PutUser(user *resources.User) error {
+	_, err := s.conn().Exec(`
+		INSERT INTO users (id, seq, name) VALUES (?, (SELECT IFNULL(MAX(seq), 0) + 1 FROM users), ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name`,
+		user.ID, user.Name)
+	return err
+}
+
+func (s *Store) DeleteUser(userID string) error {
+	_, err := s.conn().Exec(`DELETE FROM users WHERE id = ?`, userID)
+	return err
+}
+
+func (s *Store) ForEachUser(fn func(user *resources.User) error) error {
+	rows, err := s.conn().Query(`SELECT id, name FROM users ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user resources.User
+		err = rows.Scan(&user.ID, &user.Name)
+		if err != nil {
+			return err
+		}
+		err = fn(&user)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Store) GetSong(songID string) (*resources.Song, error) {
+	var song resources.Song
+	err := s.conn().QueryRow(`SELECT id, artist, title FROM songs WHERE id = ?`, songID).Scan(&song.ID, &song.Artist, &song.Title)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (s *Store) PutSong(song *resources.Song) error {
+	_, err := s.conn().Exec(`
+		INSERT INTO songs (id, seq, artist, title) VALUES (?, (SELECT IFNULL(MAX(seq), 0) + 1 FROM songs), ?, ?)
+		ON CONFLICT (id) DO UPDATE SET artist = excluded.artist, title = excluded.title`,
+		song.ID, song.Artist, song.Title)
+	return err
+}
+
+func (s *Store) DeleteSong(songID string) error {
+	_, err := s.conn().Exec(`DELETE FROM songs WHERE id = ?`, songID)
+	return err
+}
+
+func (s *Store) ForEachSong(fn func(song *resources.Song) error) error {
+	rows, err := s.conn().Query(`SELECT id, artist, title FROM songs ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var song resources.Song
+		err = rows.Scan(&song.ID, &song.Artist, &song.Title)
+		if err != nil {
+			return err
+		}
+		err = fn(&song)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Store) GetPlayList(playlistID string) (*resources.PlayList, error) {
+	playlist := resources.PlayList{SongIDs: []string{}}
+	err := s.conn().QueryRow(`SELECT id, user_id FROM playlists WHERE id = ?`, playlistID).Scan(&playlist.ID, &playlist.UserID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.conn().Query(`SELECT song_id FROM playlist_songs WHERE playlist_id = ? ORDER BY position`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var songID string
+		err = rows.Scan(&songID)
+		if err != nil {
+			return nil, err
+		}
+		playlist.SongIDs = append(playlist.SongIDs, songID)
+	}
+	return &playlist, rows.Err()
+}
+
+// Put a playlist and replace its songs
+func (s *Store) PutPlayList(playlist *resources.PlayList) error {
+	return s.update(func(q querier) error {
+		_, err := q.Exec(`
+			INSERT INTO playlists (id, seq, user_id) VALUES (?, (SELECT IFNULL(MAX(seq), 0) + 1 FROM playlists), ?)
+			ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id`,
+			playlist.ID, playlist.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.Exec(`DELETE FROM playlist_songs WHERE playlist_id = ?`, playlist.ID)
+		if err != nil {
+			return err
+		}
+
+		for position, songID := range playlist.SongIDs {
+			_, err = q.Exec(`INSERT INTO playlist_songs (playlist_id, position, song_id) VALUES (?, ?, ?)`,
+				playlist.ID, position, songID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete a playlist, its songs are deleted by the foreign key
+func (s *Store) DeletePlayList(playlistID string) error {
+	_, err := s.conn().Exec(`DELETE FROM playlists WHERE id = ?`, playlistID)
+	return err
+}
+
+// Iterate the playlists with a single query, the rows of a playlist are consecutive
+func (s *Store) ForEachPlayList(fn func(playlist *resources.PlayList) error) error {
+	rows, err := s.conn().Query(`
+		SELECT p.id, p.user_id, ps.song_id FROM playlists p
+		LEFT JOIN playlist_songs ps ON ps.playlist_id = p.id
+		ORDER BY p.seq, ps.position`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var playlist *resources.PlayList
+	for rows.Next() {
+		var id, userID string
+		var songID sql.NullString
+		err = rows.Scan(&id, &userID, &songID)
+		if err != nil {
+			return err
+		}
+
+		if playlist == nil || playlist.ID != id {
+			if playlist != nil {
+				err = fn(playlist)
+				if err != nil {
+					return err
+				}
+			}
+			playlist = &resources.PlayList{ID: id, UserID: userID, SongIDs: []string{}}
+		}
+
+		if songID.Valid {
+			playlist.SongIDs = append(playlist.SongIDs, songID.String)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil || playlist == nil {
+		return err
+	}
+	return fn(playlist)
+}
@@ -0,0 +1,204 @@
+package sqlite
+
+import (
+	"errors"
+	"highspot/resources"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Create a database of users 1 and 2, songs 1 to 3, and playlist 1 of user 1 with songs 3, 1 and 2
+func newTestStore(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "mixtape.db")
+	store, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	err = store.Update(func(storage resources.Storage) error {
+		for _, user := range []*resources.User{{ID: "1", Name: "Ana"}, {ID: "2", Name: "Ben"}} {
+			err := storage.PutUser(user)
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			err := storage.PutSong(&resources.Song{ID: id, Artist: "Zedd", Title: "Title " + id})
+			if err != nil {
+				return err
+			}
+		}
+		return storage.PutPlayList(&resources.PlayList{ID: "1", UserID: "1", SongIDs: []string{"3", "1", "2"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, path
+}
+
+// The IDs of the users, songs and playlists in storage order, and the songs of the playlists
+func contents(t *testing.T, storage resources.Storage) map[string][]string {
+	contents := map[string][]string{"users": {}, "songs": {}, "playlists": {}}
+	err := storage.ForEachUser(func(user *resources.User) error {
+		contents["users"] = append(contents["users"], user.ID)
+		return nil
+	})
+	if err == nil {
+		err = storage.ForEachSong(func(song *resources.Song) error {
+			contents["songs"] = append(contents["songs"], song.ID)
+			return nil
+		})
+	}
+	if err == nil {
+		err = storage.ForEachPlayList(func(playlist *resources.PlayList) error {
+			contents["playlists"] = append(contents["playlists"], playlist.ID)
+			contents["playlist "+playlist.ID] = playlist.SongIDs
+			return nil
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestForeignKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(storage resources.Storage) error
+		valid  bool
+	}{
+		{"a playlist of a missing user", func(storage resources.Storage) error {
+			return storage.PutPlayList(&resources.PlayList{ID: "2", UserID: "9", SongIDs: []string{"1"}})
+		}, false},
+		{"a playlist of a missing song", func(storage resources.Storage) error {
+			return storage.PutPlayList(&resources.PlayList{ID: "2", UserID: "2", SongIDs: []string{"1", "9"}})
+		}, false},
+		{"a song twice in a playlist", func(storage resources.Storage) error {
+			return storage.PutPlayList(&resources.PlayList{ID: "2", UserID: "2", SongIDs: []string{"1", "1"}})
+		}, false},
+		{"the removal of the user of a playlist", func(storage resources.Storage) error {
+			return storage.DeleteUser("1")
+		}, false},
+		{"the removal of a song of a playlist", func(storage resources.Storage) error {
+			return storage.DeleteSong("3")
+		}, false},
+		// The foreign keys are checked on commit
+		{"the removal of a song, then of its playlist", func(storage resources.Storage) error {
+			err := storage.DeleteSong("3")
+			if err != nil {
+				return err
+			}
+			return storage.DeletePlayList("1")
+		}, true},
+		{"a playlist, then its user", func(storage resources.Storage) error {
+			err := storage.PutPlayList(&resources.PlayList{ID: "2", UserID: "3", SongIDs: []string{"1"}})
+			if err != nil {
+				return err
+			}
+			return storage.PutUser(&resources.User{ID: "3", Name: "Cy"})
+		}, true},
+	}
+
+	for _, test := range tests {
+		store, _ := newTestStore(t)
+		before := contents(t, store)
+
+		err := store.Update(test.update)
+		if test.valid && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.name)
+			}
+			// The transaction is rolled back
+			if after := contents(t, store); !reflect.DeepEqual(after, before) {
+				t.Errorf("%v: expected the database to be unchanged, got %v", test.name, after)
+			}
+		}
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	store, _ := newTestStore(t)
+	before := contents(t, store)
+
+	failed := errors.New("failed")
+	err := store.Update(func(storage resources.Storage) error {
+		err := storage.PutUser(&resources.User{ID: "3", Name: "Cy"})
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the error of the update, got %v", err)
+	}
+	if after := contents(t, store); !reflect.DeepEqual(after, before) {
+		t.Errorf("expected the database to be unchanged, got %v", after)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	store, path := newTestStore(t)
+
+	err := store.Update(func(storage resources.Storage) error {
+		// An updated entity keeps its position, a removed one is added back at the end
+		err := storage.PutUser(&resources.User{ID: "1", Name: "Anna"})
+		if err != nil {
+			return err
+		}
+		err = storage.DeleteSong("1")
+		if err == nil {
+			err = storage.PutSong(&resources.Song{ID: "1", Artist: "Zedd", Title: "Clarity"})
+		}
+		if err != nil {
+			return err
+		}
+		err = storage.PutPlayList(&resources.PlayList{ID: "2", UserID: "2", SongIDs: []string{"2", "1"}})
+		if err != nil {
+			return err
+		}
+		return storage.PutPlayList(&resources.PlayList{ID: "1", UserID: "1", SongIDs: []string{"2", "3"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"users":      {"1", "2"},
+		"songs":      {"2", "3", "1"},
+		"playlists":  {"1", "2"},
+		"playlist 1": {"2", "3"},
+		"playlist 2": {"2", "1"},
+	}
+	if actual := contents(t, store); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// The database file keeps the order when it is opened again
+	store.Close()
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if actual := contents(t, store); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v after opening the database, got %v", expected, actual)
+	}
+	playlist, err := store.GetPlayList("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(playlist, &resources.PlayList{ID: "2", UserID: "2", SongIDs: []string{"2", "1"}}) {
+		t.Errorf("expected playlist 2, got %+v", playlist)
+	}
+	user, err := store.GetUser("1")
+	if err != nil || user.Name != "Anna" {
+		t.Errorf("expected the updated user, got %v %v", user, err)
+	}
+}
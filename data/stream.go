@@ -0,0 +1,287 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"highspot/data/validation"
+	"highspot/resources"
+	"io"
+	"sync"
+)
+
+//
+// The streaming ingestion reads the input JSON document one token at a time. Each element of
+// the users, songs and playlists arrays is decoded, validated against its definition in the
+// input schema and loaded in the mixtape, so only one element is in memory at a time.
+//
+
+// The input collections
+var inputCollections = []string{"users", "playlists", "songs"}
+
+// The compiled validators of the input schema definitions, by collection
+var inputValidators struct {
+	sync.Once
+	validators map[string]*validation.Validator
+	err        error
+}
+
+func getInputValidators() (map[string]*validation.Validator, error) {
+	inputValidators.Do(func() {
+		definitions := map[string]string{"users": "user", "songs": "song", "playlists": "playlist"}
+		inputValidators.validators = map[string]*validation.Validator{}
+
+		for collection, definition := range definitions {
+			validator, err := validation.NewDefinitionValidator(validation.InputSchema, definition)
+			if err != nil {
+				inputValidators.err = err
+				return
+			}
+			inputValidators.validators[collection] = validator
+		}
+	})
+	return inputValidators.validators, inputValidators.err
+}
+
+// Stream an input JSON document into the mixtape
+func StreamMixTape(r io.Reader, mixtape *resources.MixTape) error {
+	validators, err := getInputValidators()
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r)
+
+	err = expectDelim(decoder, '{', "", "object")
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		collection := token.(string)
+
+		validator, ok := validators[collection]
+		if !ok || seen[collection] {
+			return schemaError(validation.Violation{
+				Keyword: "additionalProperties",
+				Actual:  collection,
+				Message: fmt.Sprintf("Additional property %v is not allowed", collection),
+			})
+		}
+		seen[collection] = true
+
+		err = streamCollection(decoder, collection, validator, mixtape)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = expectDelim(decoder, '}', "", "object")
+	if err != nil {
+		return err
+	}
+
+	_, err = decoder.Token()
+	if err != io.EOF {
+		return errors.New("Unexpected data after the input document.")
+	}
+
+	for _, collection := range inputCollections {
+		if !seen[collection] {
+			return schemaError(validation.Violation{
+				Keyword:  "required",
+				Expected: collection,
+				Message:  fmt.Sprintf("%v is required", collection),
+			})
+		}
+	}
+
+	return mixtape.FinishLoad()
+}
+
+// Stream the elements of a collection array
+func streamCollection(decoder *json.Decoder, collection string, validator *validation.Validator, mixtape *resources.MixTape) error {
+	pointer := "/" + collection
+
+	err := expectDelim(decoder, '[', pointer, "array")
+	if err != nil {
+		return err
+	}
+
+	for index := 0; decoder.More(); index++ {
+		var element json.RawMessage
+		err = decoder.Decode(&element)
+		if err != nil {
+			return err
+		}
+
+		err = validator.ValidateAt(fmt.Sprintf("%v/%v", pointer, index), element)
+		if err != nil {
+			return err
+		}
+
+		err = loadElement(collection, element, mixtape)
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(decoder, ']', pointer, "array")
+}
+
+func loadElement(collection string, element json.RawMessage, mixtape *resources.MixTape) error {
+	switch collection {
+	case "users":
+		var user resources.User
+		err := json.Unmarshal(element, &user)
+		if err != nil {
+			return err
+		}
+		return mixtape.LoadUser(&user)
+	case "songs":
+		var song resources.Song
+		err := json.Unmarshal(element, &song)
+		if err != nil {
+			return err
+		}
+		return mixtape.LoadSong(&song)
+	}
+
+	var playlist resources.PlayList
+	err := json.Unmarshal(element, &playlist)
+	if err != nil {
+		return err
+	}
+	return mixtape.LoadPlayList(&playlist)
+}
+
+// Read the next token, which must be the delimiter of an object or an array
+func expectDelim(decoder *json.Decoder, delim json.Delim, pointer string, kind string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return schemaError(validation.Violation{
+			Pointer:  pointer,
+			Keyword:  "type",
+			Expected: kind,
+			Message:  fmt.Sprintf("Invalid type. Expected: %v", kind),
+		})
+	}
+	return nil
+}
+
+func schemaError(violation validation.Violation) error {
+	return &validation.Error{Violations: []validation.Violation{violation}}
+}
+
+//
+// The streaming output writes the mixtape one user, song or playlist at a time. Each element
+// is validated against its definition in the input schema, so the output is a valid input
+// file, and it is indented as json.Indent indents the whole document, so the output is the
+// same as the output of EncodeMixTape.
+//
+
+// Write the mixtape as an indented JSON document
+func WriteMixTape(w io.Writer, mixtape *resources.MixTape) error {
+	validators, err := getInputValidators()
+	if err != nil {
+		return err
+	}
+
+	output := mixTapeOutput{w: w, validators: validators}
+	output.write("{\n")
+
+	output.writeCollection("users", func(fn func(value interface{}) error) error {
+		return mixtape.ForEachUser(func(user *resources.User) error {
+			return fn(user)
+		})
+	})
+	output.write(",\n")
+
+	output.writeCollection("playlists", func(fn func(value interface{}) error) error {
+		return mixtape.ForEachPlayList(func(playlist *resources.PlayList) error {
+			return fn(playlist)
+		})
+	})
+	output.write(",\n")
+
+	output.writeCollection("songs", func(fn func(value interface{}) error) error {
+		return mixtape.ForEachSong(func(song *resources.Song) error {
+			return fn(song)
+		})
+	})
+	output.write("\n}")
+
+	return output.err
+}
+
+// The output of a mixtape, the first error stops the output
+type mixTapeOutput struct {
+	w          io.Writer
+	validators map[string]*validation.Validator
+	buffer     bytes.Buffer
+	err        error
+}
+
+func (o *mixTapeOutput) write(s string) {
+	if o.err != nil {
+		return
+	}
+	_, err := io.WriteString(o.w, s)
+	if err != nil {
+		o.err = fmt.Errorf("Cannot write output file. %w", err)
+	}
+}
+
+func (o *mixTapeOutput) writeCollection(collection string, forEach func(fn func(value interface{}) error) error) {
+	if o.err != nil {
+		return
+	}
+	o.write(fmt.Sprintf("  %q: [", collection))
+
+	index := 0
+	err := forEach(func(value interface{}) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("Cannot write output file. %w", err)
+		}
+
+		err = o.validators[collection].ValidateAt(fmt.Sprintf("/%v/%v", collection, index), data)
+		if err != nil {
+			return fmt.Errorf("Invalid output file. %w", err)
+		}
+
+		if index > 0 {
+			o.write(",")
+		}
+		o.write("\n    ")
+
+		o.buffer.Reset()
+		err = json.Indent(&o.buffer, data, "    ", "  ")
+		if err != nil {
+			return fmt.Errorf("Cannot write output file. %w", err)
+		}
+		o.write(o.buffer.String())
+
+		index++
+		return o.err
+	})
+	if err != nil {
+		o.err = err
+		return
+	}
+
+	if index > 0 {
+		o.write("\n  ")
+	}
+	o.write("]")
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"highspot/resources"
+)
+
+//
+// The undo changes of a changes file turn the output mixtape back into the input mixtape, so
+// a bad batch can be rolled back without a copy of the input. The users, songs and playlists
+// touched by the changes are recorded by a hook of the mixtape, and the undo changes are the
+// diff of their output values and their input values: a removed user, song or playlist is
+// added back in full, a changed one has its changed fields replaced, the songs of a playlist
+// are put back at their input positions, and a new one is removed.
+//
+// The insertion order of each collection is restored too. The longest start of the input
+// order that is still in order in the output is kept, and the later users, songs or playlists
+// are added back, or moved to the end of their collection, in their input order. The restored
+// mixtape is identical to the input mixtape in every output order.
+//
+
+// Apply the changes as ApplyChanges applies them, and return the undo changes that turn the
+// mixtape back into the mixtape before the changes. The undo changes are verified before the
+// changes are committed to the mixtape.
+func ApplyChangesWithUndo(mixtape *resources.MixTape, changes []resources.Change, mode ApplyMode) ([]ChangeResult, []resources.Change, error) {
+	//
+	// The changes are applied to a transaction, so the input mixtape is read from the mixtape
+	// until the transaction is committed. The changes are committed to the transaction from a
+	// nested transaction, whose hook records the touched entities.
+	//
+	touched := []entityKey{}
+	seen := map[entityKey]bool{}
+
+	tx := mixtape.Begin()
+	changesTx := tx.Begin()
+	changesTx.AddHook(func(collection, id string) {
+		key := entityKey{collection, id}
+		if !seen[key] {
+			seen[key] = true
+			touched = append(touched, key)
+		}
+	})
+
+	results, err := ApplyChanges(changesTx, changes, mode)
+	if err != nil {
+		return results, nil, err
+	}
+	err = changesTx.Commit()
+	if err != nil {
+		return results, nil, err
+	}
+
+	inputOrder, err := insertionOrder(mixtape)
+	if err != nil {
+		return results, nil, err
+	}
+	outputOrder, err := insertionOrder(tx)
+	if err != nil {
+		return results, nil, err
+	}
+
+	//
+	// The touched entities are diffed in input order, with the entities that are moved to
+	// the end of their collection to restore the input order.
+	//
+	moved := movedEntities(outputOrder, inputOrder)
+	keys := []entityKey{}
+	for _, collection := range collections {
+		for _, id := range inputOrder[collection] {
+			key := entityKey{collection, id}
+			if seen[key] || moved[key] {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	input, err := snapshotEntities(mixtape, keys)
+	if err != nil {
+		return results, nil, err
+	}
+	output, err := snapshotEntities(tx, append(keys, touched...))
+	if err != nil {
+		return results, nil, err
+	}
+
+	undo, err := diffSnapshots(output, input, moved)
+	if err != nil {
+		return results, nil, err
+	}
+
+	err = verifyUndo(tx, undo, mixtape)
+	if err != nil {
+		return results, nil, fmt.Errorf("Cannot verify the undo changes. %w", err)
+	}
+
+	return results, undo, tx.Commit()
+}
+
+// Check that the undo changes restore the input mixtape. The undo changes are applied to a
+// transaction of the output mixtape, which is discarded, and the restored mixtape must be
+// identical to the input mixtape in insertion order, so it is identical in every order.
+func verifyUndo(output *resources.MixTape, undo []resources.Change, input *resources.MixTape) error {
+	tx := output.Begin()
+	_, err := ApplyChanges(tx, undo, Transactional)
+	if err != nil {
+		return err
+	}
+
+	restoredJSON, err := insertionOrderJSON(tx)
+	if err != nil {
+		return err
+	}
+	inputJSON, err := insertionOrderJSON(input)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(restoredJSON, inputJSON) {
+		return errors.New("The undo changes do not restore the input mixtape.")
+	}
+	return nil
+}
+
+func insertionOrderJSON(mixtape *resources.MixTape) ([]byte, error) {
+	return json.Marshal(insertionOrderView(mixtape))
+}
+
+// The entities of the mixtape with the keys, an entity that does not exist is skipped
+func snapshotEntities(mixtape *resources.MixTape, keys []entityKey) (*snapshot, error) {
+	entities := &snapshot{}
+	for _, key := range keys {
+		var err error
+		switch key.collection {
+		case "users":
+			var user *resources.User
+			user, err = mixtape.GetUser(key.id)
+			if err == nil {
+				entities.users = append(entities.users, user)
+			}
+		case "songs":
+			var song *resources.Song
+			song, err = mixtape.GetSong(key.id)
+			if err == nil {
+				entities.songs = append(entities.songs, song)
+			}
+		case "playlists":
+			var playlist *resources.PlayList
+			playlist, err = mixtape.GetPlayList(key.id)
+			if err == nil {
+				entities.playlists = append(entities.playlists, playlist)
+			}
+		}
+
+		if err != nil && resources.Code(err) != resources.CodeNotFound {
+			return nil, err
+		}
+	}
+	return entities, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"encoding/json"
+	"highspot/resources"
+	"math/rand"
+	"testing"
+)
+
+// The mixtape document in insertion order
+func documentInInsertionOrder(t *testing.T, mixtape *resources.MixTape) string {
+	mixtape.SetSortMode(resources.SortByInsertion)
+	data, err := json.Marshal(mixtape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Moves of random users, songs and playlists of the mixtape to the end of their collections
+func randomMoves(r *rand.Rand, f *mixtapeFixture) []resources.Change {
+	changes := []resources.Change{}
+	for _, user := range f.users {
+		if r.Intn(3) == 0 {
+			changes = append(changes, moveChange("users", user.ID))
+		}
+	}
+	for _, song := range f.songs {
+		if r.Intn(3) == 0 {
+			changes = append(changes, moveChange("songs", song.ID))
+		}
+	}
+	for _, playlist := range f.playlists {
+		if r.Intn(3) == 0 {
+			changes = append(changes, moveChange("playlists", playlist.ID))
+		}
+	}
+	r.Shuffle(len(changes), func(i, j int) {
+		changes[i], changes[j] = changes[j], changes[i]
+	})
+	return changes
+}
+
+func TestUndoRoundTrip(t *testing.T) {
+	policies := []resources.ReferencePolicy{resources.RejectReferences, resources.CascadeReferences, resources.StripReferences}
+
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 500; i++ {
+		a := randomFixture(r, 0)
+
+		var b *mixtapeFixture
+		switch i % 3 {
+		case 0:
+			// Disjoint IDs, every entity is removed and added
+			b = randomFixture(r, 100)
+		default:
+			b = mutateFixture(r, a)
+		}
+
+		//
+		// The changes of the diff keep the order of the kept entities, the moves reorder them
+		//
+		changes, err := Diff(a.build(t), b.build(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, randomMoves(r, b)...)
+
+		expected := documentInInsertionOrder(t, a.build(t))
+		for _, policy := range policies {
+			mixtape := a.build(t)
+			mixtape.SetReferencePolicy(policy)
+
+			_, undo, err := ApplyChangesWithUndo(mixtape, changes, Transactional)
+			if err != nil {
+				t.Fatalf("pair %v, policy %v: %v", i, policy, err)
+			}
+
+			undoJSON, err := EncodeChanges(undo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			undo, err = DecodeChanges(undoJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ApplyChanges(mixtape, undo, Transactional)
+			if err != nil {
+				t.Fatalf("pair %v, policy %v: %v\n%s", i, policy, err, undoJSON)
+			}
+			if actual := documentInInsertionOrder(t, mixtape); actual != expected {
+				t.Fatalf("pair %v, policy %v: expected\n%v\ngot\n%v\n%s", i, policy, expected, actual, undoJSON)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xeipuuv/gojsonschema"
+	"strconv"
+	"strings"
+)
+
+// A violation of a JSON schema
+type Violation struct {
+	// The JSON pointer of the invalid value
+	Pointer string `json:"pointer"`
+	// The schema keyword that is violated
+	Keyword string `json:"keyword"`
+	// The value allowed by the schema keyword
+	Expected interface{} `json:"expected,omitempty"`
+	// The invalid value
+	Actual interface{} `json:"actual,omitempty"`
+	// The index of the invalid item when the document is an array, such as the changes file
+	Index   *int   `json:"index,omitempty"`
+	Message string `json:"message"`
+}
+
+// The error returned when a document is not valid, with every violation of the schema
+type Error struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.String())
+	}
+	return fmt.Sprintf("JSON schema validation failed.\n  %v", strings.Join(messages, "\n  "))
+}
+
+func (v Violation) String() string {
+	pointer := v.Pointer
+	if len(pointer) == 0 {
+		pointer = "(root)"
+	}
+	return fmt.Sprintf("%v: %v (%v)", pointer, v.Message, v.Keyword)
+}
+
+// Get the violations of a validation error in an error chain
+func Violations(err error) []Violation {
+	var validationErr *Error
+	if errors.As(err, &validationErr) {
+		return validationErr.Violations
+	}
+	return nil
+}
+
+// The schema keywords of the gojsonschema error types
+var keywords = map[string]string{
+	"false":                           "false",
+	"required":                        "required",
+	"invalid_type":                    "type",
+	"number_any_of":                   "anyOf",
+	"number_one_of":                   "oneOf",
+	"number_all_of":                   "allOf",
+	"number_not":                      "not",
+	"missing_dependency":              "dependencies",
+	"const":                           "const",
+	"enum":                            "enum",
+	"array_no_additional_items":       "additionalItems",
+	"array_min_items":                 "minItems",
+	"array_max_items":                 "maxItems",
+	"unique":                          "uniqueItems",
+	"contains":                        "contains",
+	"array_min_properties":            "minProperties",
+	"array_max_properties":            "maxProperties",
+	"additional_property_not_allowed": "additionalProperties",
+	"invalid_property_pattern":        "patternProperties",
+	"invalid_property_name":           "propertyNames",
+	"string_gte":                      "minLength",
+	"string_lte":                      "maxLength",
+	"pattern":                         "pattern",
+	"format":                          "format",
+	"multiple_of":                     "multipleOf",
+	"number_gte":                      "minimum",
+	"number_gt":                       "exclusiveMinimum",
+	"number_lte":                      "maximum",
+	"number_lt":                       "exclusiveMaximum",
+	"condition_then":                  "then",
+	"condition_else":                  "else",
+}
+
+func newViolation(desc gojsonschema.ResultError, indexed bool) Violation {
+	details := desc.Details()
+
+	violation := Violation{
+		Pointer: contextPointer(desc.Context()),
+		Keyword: keywords[desc.Type()],
+		Actual:  desc.Value(),
+		Message: desc.Description(),
+	}
+	if len(violation.Keyword) == 0 {
+		violation.Keyword = desc.Type()
+	}
+
+	switch desc.Type() {
+	case "required":
+		violation.Expected = details["property"]
+	case "invalid_type":
+		violation.Expected = details["expected"]
+		violation.Actual = details["given"]
+	case "const", "enum":
+		violation.Expected = details["allowed"]
+	case "array_min_items", "array_min_properties", "string_gte", "number_gte", "number_gt":
+		violation.Expected = details["min"]
+	case "array_max_items", "array_max_properties", "string_lte", "number_lte", "number_lt":
+		violation.Expected = details["max"]
+	case "pattern":
+		violation.Expected = details["pattern"]
+	case "format":
+		violation.Expected = details["format"]
+	case "additional_property_not_allowed":
+		violation.Actual = details["property"]
+	}
+
+	if indexed {
+		tokens := strings.SplitN(violation.Pointer, "/", 3)
+		if len(tokens) > 1 {
+			if index, err := strconv.Atoi(tokens[1]); err == nil {
+				violation.Index = &index
+			}
+		}
+	}
+
+	return violation
+}
+
+// Convert a gojsonschema context, such as (root).0.path, to a JSON pointer, such as /0/path
+func contextPointer(context *gojsonschema.JsonContext) string {
+	if context == nil {
+		return ""
+	}
+	return strings.TrimPrefix(context.String("/"), gojsonschema.STRING_CONTEXT_ROOT)
+}
@@ -9,13 +9,22 @@ var PatchSchema = `{
                 "type": "string",
                 "enum": [
                     "add",
-                    "remove"
+                    "remove",
+                    "replace",
+                    "move",
+                    "copy",
+                    "test"
                 ]
             },
             "path": {
                 "type": "string",
-                "maxLength": 32,
-                "pattern": "^(/playlists/-|/playlists/[0-9]+|/playlists/[0-9]+/song_ids/-)$"
+                "maxLength": 256,
+                "pattern": "^(/([^~/]|~[01])*)*$"
+            },
+            "from": {
+                "type": "string",
+                "maxLength": 256,
+                "pattern": "^(/([^~/]|~[01])*)*$"
             },
             "value": {}
         },
@@ -23,6 +32,43 @@ var PatchSchema = `{
         "required": [
             "op",
             "path"
+        ],
+        "allOf": [
+            {
+                "if": {
+                    "properties": {
+                        "op": {
+                            "enum": [
+                                "add",
+                                "replace",
+                                "test"
+                            ]
+                        }
+                    }
+                },
+                "then": {
+                    "required": [
+                        "value"
+                    ]
+                }
+            },
+            {
+                "if": {
+                    "properties": {
+                        "op": {
+                            "enum": [
+                                "move",
+                                "copy"
+                            ]
+                        }
+                    }
+                },
+                "then": {
+                    "required": [
+                        "from"
+                    ]
+                }
+            }
         ]
     }
 }`
@@ -61,6 +107,54 @@ var PatchPlaylistSchema = `{
     ]
 }`
 
+var PatchUserSchema = `{
+    "type": "object",
+    "properties": {
+        "id": {
+            "type": "string",
+            "minLength": 1,
+            "maxLength": 10
+        },
+        "name": {
+            "type": "string",
+            "minLength": 1,
+            "maxLength": 512
+        }
+    },
+    "additionalProperties": false,
+    "required": [
+        "id",
+        "name"
+    ]
+}`
+
+var PatchSongSchema = `{
+    "type": "object",
+    "properties": {
+        "id": {
+            "type": "string",
+            "minLength": 1,
+            "maxLength": 10
+        },
+        "artist": {
+            "type": "string",
+            "minLength": 1,
+            "maxLength": 512
+        },
+        "title": {
+            "type": "string",
+            "minLength": 1,
+            "maxLength": 512
+        }
+    },
+    "additionalProperties": false,
+    "required": [
+        "id",
+        "artist",
+        "title"
+    ]
+}`
+
 var InputSchema = `{
     "definitions": {
         "user": {
@@ -174,3 +268,28 @@ var InputSchema = `{
         "songs"
     ]
 }`
+
+var MergePatchSchema = `{
+    "type": "object",
+    "properties": {
+        "users": {
+            "type": "object",
+            "additionalProperties": {
+                "type": ["object", "null"]
+            }
+        },
+        "playlists": {
+            "type": "object",
+            "additionalProperties": {
+                "type": ["object", "null"]
+            }
+        },
+        "songs": {
+            "type": "object",
+            "additionalProperties": {
+                "type": ["object", "null"]
+            }
+        }
+    },
+    "additionalProperties": false
+}`
@@ -3,32 +3,132 @@ package validation
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/xeipuuv/gojsonschema"
-	"log"
+	"sort"
+	"sync"
 )
 
+// Validate a document against a schema, the schema is compiled on its first use
 func Validate(schemaDocument, document string) error {
+	validator, err := getValidator(schemaDocument)
+	if err != nil {
+		return err
+	}
+
+	return validator.validate(gojsonschema.NewStringLoader(document), "")
+}
+
+// The compiled validators of the schema documents
+var validators struct {
+	sync.Mutex
+	bySchema map[string]*Validator
+}
+
+func getValidator(schemaDocument string) (*Validator, error) {
+	validators.Lock()
+	defer validators.Unlock()
+
+	if validator, ok := validators.bySchema[schemaDocument]; ok {
+		return validator, nil
+	}
+
+	validator, err := NewValidator(schemaDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	if validators.bySchema == nil {
+		validators.bySchema = map[string]*Validator{}
+	}
+	validators.bySchema[schemaDocument] = validator
+	return validator, nil
+}
+
+// A compiled schema, to validate many documents against the same schema
+type Validator struct {
+	schema  *gojsonschema.Schema
+	indexed bool
+}
+
+func NewValidator(schemaDocument string) (*Validator, error) {
 	var schema map[string]interface{}
 	err := compactAndUnmarshalJson(schemaDocument, &schema)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	schemaLoader := gojsonschema.NewGoLoader(schema)
-	documentLoader := gojsonschema.NewStringLoader(document)
+	return newValidator(schema)
+}
+
+// Compile a definition of the schema, such as the user of the input schema
+func NewDefinitionValidator(schemaDocument, definition string) (*Validator, error) {
+	var schema map[string]interface{}
+	err := compactAndUnmarshalJson(schemaDocument, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions, _ := schema["definitions"].(map[string]interface{})
+	if _, ok := definitions[definition]; !ok {
+		return nil, fmt.Errorf("The schema has no definition %v.", definition)
+	}
+
+	return newValidator(map[string]interface{}{
+		"definitions": definitions,
+		"$ref":        "#/definitions/" + definition,
+	})
+}
+
+func newValidator(schema map[string]interface{}) (*Validator, error) {
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("JSON schema validation failed: %w.", err)
+	}
+
+	validator := Validator{
+		schema:  compiled,
+		indexed: schema["type"] == "array",
+	}
+	return &validator, nil
+}
+
+// Validate a document
+func (v *Validator) Validate(document []byte) error {
+	return v.validate(gojsonschema.NewBytesLoader(document), "")
+}
+
+// Validate a document that is at the pointer of an enclosing document, the pointer is the
+// prefix of the pointer of each violation
+func (v *Validator) ValidateAt(pointer string, document []byte) error {
+	return v.validate(gojsonschema.NewBytesLoader(document), pointer)
+}
 
-	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+func (v *Validator) validate(documentLoader gojsonschema.JSONLoader, pointer string) error {
+	result, err := v.schema.Validate(documentLoader)
 	if err != nil {
-		return errors.New(fmt.Sprintf("JSON schema validation failed: %v.", err))
+		return fmt.Errorf("JSON schema validation failed: %w.", err)
 	}
 
 	if !result.Valid() {
+		//
+		// Every violation is returned in pointer order, an array document has the index of
+		// each invalid item
+		//
+		validationErr := &Error{}
 		for _, desc := range result.Errors() {
-			log.Printf("Error %v.\n", desc)
+			violation := newViolation(desc, v.indexed)
+			violation.Pointer = pointer + violation.Pointer
+			validationErr.Violations = append(validationErr.Violations, violation)
 		}
-		return errors.New("JSON schema validation failed.")
+		sort.SliceStable(validationErr.Violations, func(i, j int) bool {
+			a, b := validationErr.Violations[i], validationErr.Violations[j]
+			if a.Pointer != b.Pointer {
+				return a.Pointer < b.Pointer
+			}
+			return a.Keyword < b.Keyword
+		})
+		return validationErr
 	}
 
 	return nil
@@ -0,0 +1,111 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestValidateCompilesASchemaOnce(t *testing.T) {
+	first, err := getValidator(PatchSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getValidator(PatchSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("expected the compiled validator of the schema to be reused")
+	}
+
+	other, err := getValidator(InputSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Errorf("expected another validator for another schema")
+	}
+
+	err = Validate(PatchSchema, `[{"op": "remove", "path": "/users/1"}]`)
+	if err != nil {
+		t.Error(err)
+	}
+	err = Validate(PatchSchema, `[{"op": "remove"}]`)
+	if err == nil {
+		t.Errorf("expected an invalid change with the cached validator")
+	}
+}
+
+// A violation as its pointer, keyword, expected value and index
+func describe(violations []Violation) []string {
+	descriptions := []string{}
+	for _, violation := range violations {
+		description := fmt.Sprintf("%v %v %v", violation.Pointer, violation.Keyword, violation.Expected)
+		if violation.Index != nil {
+			description += fmt.Sprintf(" index %v", *violation.Index)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		name       string
+		schema     string
+		document   string
+		violations []string
+		message    string
+	}{
+		{"valid changes", PatchSchema, `[{"op": "remove", "path": "/users/1"}]`, []string{}, ""},
+		{"changes", PatchSchema, `[{"op": "remove", "path": "/users/1"}, {"op": "bogus", "path": 3}]`, []string{
+			`/1/op enum "add", "remove", "replace", "move", "copy", "test" index 1`,
+			"/1/path type string index 1",
+		}, "JSON schema validation failed.\n" +
+			"  /1/op: 1.op must be one of the following: \"add\", \"remove\", \"replace\", \"move\", \"copy\", \"test\" (enum)\n" +
+			"  /1/path: Invalid type. Expected: string, given: integer (type)"},
+		{"input", InputSchema, `{"users": [{"id": "1", "name": ""}], "songs": [], "extra": 1}`, []string{
+			" additionalProperties <nil>",
+			" required playlists",
+			"/users/0/name minLength 1",
+		}, "JSON schema validation failed.\n" +
+			"  (root): Additional property extra is not allowed (additionalProperties)\n" +
+			"  (root): playlists is required (required)\n" +
+			"  /users/0/name: String length must be greater than or equal to 1 (minLength)"},
+	}
+
+	for _, test := range tests {
+		err := Validate(test.schema, test.document)
+		if actual := describe(Violations(err)); !reflect.DeepEqual(actual, test.violations) {
+			t.Errorf("%v: expected the violations %q, got %q", test.name, test.violations, actual)
+		}
+		if err != nil && err.Error() != test.message {
+			t.Errorf("%v: expected the message\n%v\ngot\n%v", test.name, test.message, err)
+		}
+	}
+
+	// The violations are found in an error chain
+	err := fmt.Errorf("Invalid changes file. %w", Validate(PatchSchema, `[{"op": "remove", "path": 3}]`))
+	if actual := describe(Violations(err)); !reflect.DeepEqual(actual, []string{"/0/path type string index 0"}) {
+		t.Errorf("expected the violations of the wrapped error, got %q", actual)
+	}
+}
+
+func TestValidateAt(t *testing.T) {
+	validator, err := NewDefinitionValidator(InputSchema, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = validator.ValidateAt("/songs/2", []byte(`{"id": "3", "artist": "Zedd"}`))
+	expected := []string{"/songs/2 required title"}
+	if actual := describe(Violations(err)); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected the violations %q, got %q", expected, actual)
+	}
+
+	_, err = NewDefinitionValidator(InputSchema, "artist")
+	if err == nil {
+		t.Errorf("expected an error for a missing definition")
+	}
+}
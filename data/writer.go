@@ -1,5 +1,15 @@
 package data
 
+import (
+	"io"
+)
+
 type Writer interface {
 	Write(data []byte) error
 }
+
+// A stream writer writes the output incrementally with write, for outputs that do not fit in
+// memory. The output is replaced only when write succeeds, otherwise it is left unchanged.
+type StreamWriter interface {
+	WriteStream(write func(w io.Writer) error) error
+}
@@ -1,7 +1,9 @@
 package resources
 
+// A change is a JSON patch (RFC 6902) operation.
 type Change struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
-	Value interface{} `json:"value"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
 }
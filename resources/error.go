@@ -0,0 +1,66 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An error code classifies an error for machine-readable reports
+type ErrorCode string
+
+const (
+	CodeError        ErrorCode = "error"
+	CodeInvalidPath  ErrorCode = "invalid_path"
+	CodeInvalidValue ErrorCode = "invalid_value"
+	CodeNotFound     ErrorCode = "not_found"
+	CodeDuplicate    ErrorCode = "duplicate"
+	CodeOutOfRange   ErrorCode = "out_of_range"
+	CodeLimit        ErrorCode = "limit_exceeded"
+	CodeReferenced   ErrorCode = "referenced"
+	CodeUnsupported  ErrorCode = "unsupported"
+	CodeTestFailed   ErrorCode = "test_failed"
+	CodeAborted      ErrorCode = "aborted"
+	CodeRolledBack   ErrorCode = "rolled_back"
+
+	CodePreconditionFailed   ErrorCode = "precondition_failed"
+	CodePreconditionRequired ErrorCode = "precondition_required"
+)
+
+// An error with an error code, and the error that caused it
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Err     error
+}
+
+func NewError(code ErrorCode, format string, args ...interface{}) error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func WrapError(code ErrorCode, err error, format string, args ...interface{}) error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Err:     err,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Get the error code of an error, an error without a code is CodeError
+func Code(err error) ErrorCode {
+	var codeErr *Error
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	return CodeError
+}
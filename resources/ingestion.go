@@ -0,0 +1,102 @@
+package resources
+
+import (
+	"strconv"
+)
+
+// The ingest mode controls what happens to an invalid playlist in the input
+type IngestMode int
+
+const (
+	// An invalid playlist is dropped and reported
+	IngestLenient IngestMode = iota
+	// An invalid playlist fails the ingestion
+	IngestStrict
+	// An invalid playlist is repaired when possible, each fix is reported
+	IngestRepair
+)
+
+// Parse the command line name of an ingest mode
+func ParseIngestMode(name string) (IngestMode, error) {
+	switch name {
+	case "lenient":
+		return IngestLenient, nil
+	case "strict":
+		return IngestStrict, nil
+	case "repair":
+		return IngestRepair, nil
+	}
+	return IngestLenient, NewError(CodeInvalidValue, "Unknown ingest mode %v.", name)
+}
+
+// The action taken for an ingestion issue
+type IssueAction string
+
+const (
+	// The playlist is not in the storage model
+	Dropped IssueAction = "dropped"
+	// The playlist is fixed and added to the storage model
+	Repaired IssueAction = "repaired"
+)
+
+// An issue found in a playlist of the input
+type Issue struct {
+	PlayListID string      `json:"playlist_id"`
+	Action     IssueAction `json:"action"`
+	Code       ErrorCode   `json:"code"`
+	Message    string      `json:"message"`
+}
+
+// Set the ingest mode before the mixtape is unmarshalled, the default is lenient
+func (m *MixTape) SetIngestMode(mode IngestMode) {
+	m.ingestMode = mode
+}
+
+// Get the issues found in the playlists of the input
+func (m *MixTape) IngestionIssues() []Issue {
+	return append([]Issue{}, m.issues...)
+}
+
+func (m *MixTape) addIssue(playlistID string, action IssueAction, err error) {
+	m.issues = append(m.issues, Issue{
+		PlayListID: playlistID,
+		Action:     action,
+		Code:       Code(err),
+		Message:    err.Error(),
+	})
+}
+
+// Repair the song IDs of an input playlist. Unknown and duplicate song IDs are removed,
+// and the playlist is truncated to the maximum number of songs. The playlist ID and user
+// cannot be repaired.
+func (m *MixTape) repairPlayList(playlist *PlayList) {
+	songIDs := make([]string, 0, len(playlist.SongIDs))
+	seen := make(map[string]bool, len(playlist.SongIDs))
+
+	for _, songID := range playlist.SongIDs {
+		if _, err := strconv.ParseUint(songID, 10, 32); err != nil {
+			m.addIssue(playlist.ID, Repaired, NewError(CodeInvalidValue, "Removed invalid song ID %v.", songID))
+			continue
+		}
+
+		if song, err := m.storage.GetSong(songID); err != nil || song == nil {
+			m.addIssue(playlist.ID, Repaired, NewError(CodeNotFound, "Removed unknown song ID %v.", songID))
+			continue
+		}
+
+		if seen[songID] {
+			m.addIssue(playlist.ID, Repaired, NewError(CodeDuplicate, "Removed duplicate song ID %v.", songID))
+			continue
+		}
+
+		seen[songID] = true
+		songIDs = append(songIDs, songID)
+	}
+
+	if len(songIDs) > MaxPlayListSongs {
+		m.addIssue(playlist.ID, Repaired, NewError(CodeLimit, "Removed %v songs after the first %v.", len(songIDs)-MaxPlayListSongs, MaxPlayListSongs))
+		songIDs = songIDs[:MaxPlayListSongs]
+	}
+
+	playlist.SongIDs = songIDs
+}
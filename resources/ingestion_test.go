@@ -0,0 +1,116 @@
+package resources
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Playlist 1 is valid, playlist 2 has an unknown user, playlist 3 has an unknown song and a
+// duplicate song, and playlist 4 only has an unknown song
+const ingestionTestMixTape = `{
+  "users": [{"id": "1", "name": "Ana"}, {"id": "2", "name": "Ben"}],
+  "playlists": [
+    {"id": "1", "user_id": "1", "song_ids": ["1", "2"]},
+    {"id": "2", "user_id": "9", "song_ids": ["1"]},
+    {"id": "3", "user_id": "1", "song_ids": ["1", "9", "1", "2"]},
+    {"id": "4", "user_id": "2", "song_ids": ["9"]}
+  ],
+  "songs": [
+    {"id": "1", "artist": "Zedd", "title": "Clarity"},
+    {"id": "2", "artist": "Zedd", "title": "Stay"}
+  ]
+}`
+
+// Ingest the input by unmarshalling it, or by loading it one entity at a time
+func ingest(mode IngestMode, load bool) (*MixTape, error) {
+	if !load {
+		var mixtape MixTape
+		mixtape.SetIngestMode(mode)
+		err := json.Unmarshal([]byte(ingestionTestMixTape), &mixtape)
+		return &mixtape, err
+	}
+
+	var input struct {
+		Users     []*User     `json:"users"`
+		PlayLists []*PlayList `json:"playlists"`
+		Songs     []*Song     `json:"songs"`
+	}
+	err := json.Unmarshal([]byte(ingestionTestMixTape), &input)
+	if err != nil {
+		return nil, err
+	}
+
+	mixtape := NewMixTape()
+	mixtape.SetIngestMode(mode)
+	for _, user := range input.Users {
+		err = mixtape.LoadUser(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, playlist := range input.PlayLists {
+		err = mixtape.LoadPlayList(playlist)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, song := range input.Songs {
+		err = mixtape.LoadSong(song)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mixtape, mixtape.FinishLoad()
+}
+
+func TestIngestModes(t *testing.T) {
+	tests := []struct {
+		mode      IngestMode
+		playlists map[string][]string
+		issues    []string
+	}{
+		{IngestLenient, map[string][]string{"1": {"1", "2"}}, []string{
+			"2 dropped not_found",
+			"3 dropped not_found",
+			"4 dropped not_found",
+		}},
+		{IngestRepair, map[string][]string{"1": {"1", "2"}, "3": {"1", "2"}}, []string{
+			"2 dropped not_found",
+			"3 repaired not_found",
+			"3 repaired duplicate",
+			"4 repaired not_found",
+			"4 dropped limit_exceeded",
+		}},
+	}
+
+	for _, load := range []bool{false, true} {
+		for _, test := range tests {
+			mixtape, err := ingest(test.mode, load)
+			if err != nil {
+				t.Fatalf("mode %v, load %v: %v", test.mode, load, err)
+			}
+
+			if actual := playListSongs(t, mixtape); !reflect.DeepEqual(actual, test.playlists) {
+				t.Errorf("mode %v, load %v: expected the playlists %v, got %v", test.mode, load, test.playlists, actual)
+			}
+
+			issues := []string{}
+			for _, issue := range mixtape.IngestionIssues() {
+				issues = append(issues, issue.PlayListID+" "+string(issue.Action)+" "+string(issue.Code))
+			}
+			if !reflect.DeepEqual(issues, test.issues) {
+				t.Errorf("mode %v, load %v: expected the issues %v, got %v", test.mode, load, test.issues, issues)
+			}
+		}
+
+		//
+		// In strict mode the first invalid playlist fails the ingestion
+		//
+		_, err := ingest(IngestStrict, load)
+		if Code(err) != CodeNotFound || !strings.HasPrefix(err.Error(), "Playlist ID 2 is invalid.") {
+			t.Errorf("strict mode, load %v: expected the not found error of playlist 2, got %v", load, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package resources
+
+//
+// The load methods add the entities of an input that is streamed one entity at a time,
+// instead of unmarshalled as a whole. The users and songs are validated as they are loaded.
+// The songs of a playlist can follow it in the input, so the playlists are validated by
+// FinishLoad, with the ingest mode.
+//
+
+// Load a user of the input
+func (m *MixTape) LoadUser(user *User) error {
+	return m.validateAndAddUser(user)
+}
+
+// Load a song of the input
+func (m *MixTape) LoadSong(song *Song) error {
+	return m.validateAndAddSong(song)
+}
+
+// Load a playlist of the input, it is validated by FinishLoad
+func (m *MixTape) LoadPlayList(playlist *PlayList) error {
+	existing, err := m.storage.GetPlayList(playlist.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return m.rejectPlayList(playlist, NewError(CodeDuplicate, "Duplicate playlist ID %v.", playlist.ID))
+	}
+
+	return m.putPlayList(playlist)
+}
+
+// Validate the loaded playlists, in the order they were loaded. Only the playlist IDs are
+// kept in memory.
+func (m *MixTape) FinishLoad() error {
+	playlistIDs, err := m.findPlayLists(func(playlist *PlayList) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, playlistID := range playlistIDs {
+		playlist, err := m.GetPlayList(playlistID)
+		if err != nil {
+			return err
+		}
+
+		err = m.deletePlayList(playlistID)
+		if err != nil {
+			return err
+		}
+
+		err = m.ingestPlayList(playlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,7 +3,6 @@ package resources
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"strconv"
 )
 
@@ -13,18 +12,41 @@ type MixTapeApiModel struct {
 	Songs     []*Song     `json:"songs"`
 }
 
-// The storage model is an in-memory key/value store
-type MixTapeStorageModel struct {
-	userMap        map[string]*User     `json:"-"'`
-	songsMap       map[string]*Song     `json:"-"'`
-	playListMap    map[string]*PlayList `json:"-"'`
-}
-
 type MixTape struct {
 	MixTapeApiModel
-	MixTapeStorageModel
+	storage         Storage
+	hooks           []Hook
+	sortMode        SortMode
+	referencePolicy ReferencePolicy
+	ingestMode      IngestMode
+	issues          []Issue
+	parent          *MixTape
+	changed         []changedEntity
+}
+
+// A user, song or playlist changed by a transaction, the hooks are called for it on commit
+type changedEntity struct {
+	collection string
+	id         string
+}
+
+// Create an empty mixtape, in the in-memory storage
+func NewMixTape() *MixTape {
+	return NewMixTapeWithStorage(NewMixTapeStorageModel())
 }
 
+// Create a mixtape in the storage. The storage is empty when the mixtape is loaded, otherwise
+// it has the entities of a valid mixtape.
+func NewMixTapeWithStorage(storage Storage) *MixTape {
+	return &MixTape{
+		storage: storage,
+	}
+}
+
+// A hook is called when a user, song or playlist is put or deleted, with the collection,
+// users, songs or playlists, and the ID of the entity
+type Hook func(collection, id string)
+
 //
 // UnmarshalJSON is called when the mixtape input JSON file is unmarshalled (deserialized).
 // The input data is validated and used to populate the key/value storage model.
@@ -46,70 +68,578 @@ func (m *MixTape) UnmarshalJSON(data []byte) error {
 //
 // MarshalJSON is called when the mixtape data is marshalled (serialized) to produce
 // the output JSON file.
-// The playlist storage model is written to the API model which is then marshalled.
-// Only the playlist has changes.
+// The storage model is written to the API model, in the order of the sort mode, which
+// is then marshalled.
 //
 func (m *MixTape) MarshalJSON() ([]byte, error) {
-	m.PlayLists = make([]*PlayList, 0, len(m.PlayLists))
+	var err error
+	m.Users, err = m.GetUsers()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, val := range m.playListMap {
-		m.PlayLists = append(m.PlayLists, val)
+	m.PlayLists, err = m.GetPlayLists()
+	if err != nil {
+		return nil, err
+	}
+
+	m.Songs, err = m.GetSongs()
+	if err != nil {
+		return nil, err
 	}
 
 	return json.Marshal(&m.MixTapeApiModel)
 }
 
+// Set the order of the users, songs and playlists, the default is the insertion order
+func (m *MixTape) SetSortMode(mode SortMode) {
+	m.sortMode = mode
+}
+
+// Set the policy for the playlists of a removed user or song, the default is to reject the removal
+func (m *MixTape) SetReferencePolicy(policy ReferencePolicy) {
+	m.referencePolicy = policy
+}
+
+// Add a hook that is called after each change of a user, song or playlist. The changes of a
+// transaction call the hooks when the transaction is committed, so the changes of a discarded
+// transaction never call them.
+func (m *MixTape) AddHook(hook Hook) {
+	m.hooks = append(m.hooks, hook)
+}
+
+//
+// Begin a transaction. The changes to the returned mixtape are kept in memory over the
+// storage of the original mixtape, and they are written to the storage by Commit. A
+// transaction that is not committed is discarded. A hook added to the transaction is only
+// called for its own changes, when it is committed.
+//
+func (m *MixTape) Begin() *MixTape {
+	tx := MixTape{
+		MixTapeApiModel: m.MixTapeApiModel,
+		storage:         newOverlayStorage(m.storage),
+		sortMode:        m.sortMode,
+		referencePolicy: m.referencePolicy,
+		parent:          m,
+	}
+
+	return &tx
+}
+
+// Commit a transaction started by Begin. The hooks of the transaction are called for each
+// change, then the hooks of the mixtape, or the hooks of the parent transaction when it is
+// committed in turn.
+func (m *MixTape) Commit() error {
+	if m.parent == nil {
+		return errors.New("The mixtape is not a transaction.")
+	}
+
+	err := m.storage.(*overlayStorage).commit()
+	if err != nil {
+		return err
+	}
+
+	parent, changed := m.parent, m.changed
+	m.parent, m.changed = nil, nil
+
+	for _, entity := range changed {
+		for _, hook := range m.hooks {
+			hook(entity.collection, entity.id)
+		}
+		parent.callHooks(entity.collection, entity.id, nil)
+	}
+
+	return nil
+}
+
 // Add a playlist to the storage model
 func (m *MixTape) AddPlayList(playlist *PlayList) error {
 	return m.validateAndAddPlaylist(playlist)
 }
 
+// Get a copy of a user from the storage model
+func (m *MixTape) GetUser(userID string) (*User, error) {
+	user, err := m.storage.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, NewError(CodeNotFound, "User ID %v does not exist.", userID)
+	}
+
+	copy := *user
+	return &copy, nil
+}
+
+// Get a copy of the users in the storage model
+func (m *MixTape) GetUsers() ([]*User, error) {
+	users := []*User{}
+	err := m.storage.ForEachUser(func(user *User) error {
+		copy := *user
+		users = append(users, &copy)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortUsers(users, m.sortMode)
+	return users, nil
+}
+
+// Iterate copies of the users in the output order. In insertion order the users are read
+// from the storage one at a time, instead of as a slice.
+func (m *MixTape) ForEachUser(fn func(user *User) error) error {
+	if m.sortMode != SortByInsertion {
+		users, err := m.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			err = fn(user)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return m.storage.ForEachUser(func(user *User) error {
+		copy := *user
+		return fn(&copy)
+	})
+}
+
+// Get a copy of a song from the storage model
+func (m *MixTape) GetSong(songID string) (*Song, error) {
+	song, err := m.storage.GetSong(songID)
+	if err != nil {
+		return nil, err
+	}
+	if song == nil {
+		return nil, NewError(CodeNotFound, "Song ID %v does not exist.", songID)
+	}
+
+	copy := *song
+	return &copy, nil
+}
+
+// Get a copy of the songs in the storage model
+func (m *MixTape) GetSongs() ([]*Song, error) {
+	songs := []*Song{}
+	err := m.storage.ForEachSong(func(song *Song) error {
+		copy := *song
+		songs = append(songs, &copy)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortSongs(songs, m.sortMode)
+	return songs, nil
+}
+
+// Iterate copies of the songs in the output order
+func (m *MixTape) ForEachSong(fn func(song *Song) error) error {
+	if m.sortMode != SortByInsertion {
+		songs, err := m.GetSongs()
+		if err != nil {
+			return err
+		}
+		for _, song := range songs {
+			err = fn(song)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return m.storage.ForEachSong(func(song *Song) error {
+		copy := *song
+		return fn(&copy)
+	})
+}
+
+// Add a user to the storage model
+func (m *MixTape) AddUser(user *User) error {
+	return m.validateAndAddUser(user)
+}
+
+// Replace an existing user in the storage model
+func (m *MixTape) ReplaceUser(user *User) error {
+	_, err := m.GetUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return m.putUser(user)
+}
+
+// Remove a user from the storage model, the playlists of the user are handled by the reference policy
+func (m *MixTape) RemoveUser(userID string) error {
+	_, err := m.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	playlistIDs, err := m.findPlayLists(func(playlist *PlayList) bool {
+		return playlist.UserID == userID
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(playlistIDs) != 0 {
+		if m.referencePolicy == RejectReferences {
+			return NewError(CodeReferenced, "User ID %v has %v playlists.", userID, len(playlistIDs))
+		}
+
+		for _, playlistID := range playlistIDs {
+			err = m.deletePlayList(playlistID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.deleteUser(userID)
+}
+
+// Move a user to the end of the users, the playlists of the user are unchanged
+func (m *MixTape) MoveUserToEnd(userID string) error {
+	user, err := m.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	err = m.deleteUser(userID)
+	if err != nil {
+		return err
+	}
+	return m.putUser(user)
+}
+
+// Add a song to the storage model
+func (m *MixTape) AddSong(song *Song) error {
+	return m.validateAndAddSong(song)
+}
+
+// Replace an existing song in the storage model
+func (m *MixTape) ReplaceSong(song *Song) error {
+	_, err := m.GetSong(song.ID)
+	if err != nil {
+		return err
+	}
+
+	return m.putSong(song)
+}
+
+// Remove a song from the storage model, the playlists of the song are handled by the reference policy
+func (m *MixTape) RemoveSong(songID string) error {
+	_, err := m.GetSong(songID)
+	if err != nil {
+		return err
+	}
+
+	playlistIDs, err := m.findPlayLists(func(playlist *PlayList) bool {
+		for _, id := range playlist.SongIDs {
+			if id == songID {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(playlistIDs) != 0 {
+		switch m.referencePolicy {
+		case RejectReferences:
+			return NewError(CodeReferenced, "Song ID %v is in %v playlists.", songID, len(playlistIDs))
+		case CascadeReferences:
+			for _, playlistID := range playlistIDs {
+				err = m.deletePlayList(playlistID)
+				if err != nil {
+					return err
+				}
+			}
+		case StripReferences:
+			err = m.stripSong(playlistIDs, songID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.deleteSong(songID)
+}
+
+// Move a song to the end of the songs, the playlists of the song are unchanged
+func (m *MixTape) MoveSongToEnd(songID string) error {
+	song, err := m.GetSong(songID)
+	if err != nil {
+		return err
+	}
+
+	err = m.deleteSong(songID)
+	if err != nil {
+		return err
+	}
+	return m.putSong(song)
+}
+
+// Remove a song from the playlists, a playlist without songs is removed
+func (m *MixTape) stripSong(playlistIDs []string, songID string) error {
+	for _, playlistID := range playlistIDs {
+		playlist, err := m.GetPlayList(playlistID)
+		if err != nil {
+			return err
+		}
+
+		songIDs := make([]string, 0, len(playlist.SongIDs))
+		for _, id := range playlist.SongIDs {
+			if id != songID {
+				songIDs = append(songIDs, id)
+			}
+		}
+		playlist.SongIDs = songIDs
+
+		if len(songIDs) == 0 {
+			err = m.deletePlayList(playlistID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = m.savePlayList(playlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find the IDs of the playlists that match, in insertion order
+func (m *MixTape) findPlayLists(match func(playlist *PlayList) bool) ([]string, error) {
+	playlistIDs := []string{}
+	err := m.storage.ForEachPlayList(func(playlist *PlayList) error {
+		if match(playlist) {
+			playlistIDs = append(playlistIDs, playlist.ID)
+		}
+		return nil
+	})
+	return playlistIDs, err
+}
+
+// Get a copy of a playlist from the storage model
+func (m *MixTape) GetPlayList(playlistID string) (*PlayList, error) {
+	playlist, err := m.storage.GetPlayList(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	if playlist == nil {
+		return nil, NewError(CodeNotFound, "Playlist ID %v does not exist.", playlistID)
+	}
+
+	return playlist.copy(), nil
+}
+
+// Get a copy of the playlists in the storage model
+func (m *MixTape) GetPlayLists() ([]*PlayList, error) {
+	playlists := []*PlayList{}
+	err := m.storage.ForEachPlayList(func(playlist *PlayList) error {
+		playlists = append(playlists, playlist.copy())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortPlayLists(playlists, m.sortMode)
+	return playlists, nil
+}
+
+// Iterate copies of the playlists in the output order
+func (m *MixTape) ForEachPlayList(fn func(playlist *PlayList) error) error {
+	if m.sortMode != SortByInsertion {
+		playlists, err := m.GetPlayLists()
+		if err != nil {
+			return err
+		}
+		for _, playlist := range playlists {
+			err = fn(playlist)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return m.storage.ForEachPlayList(func(playlist *PlayList) error {
+		return fn(playlist.copy())
+	})
+}
+
+// Replace an existing playlist in the storage model
+func (m *MixTape) ReplacePlayList(playlist *PlayList) error {
+	_, err := m.GetPlayList(playlist.ID)
+	if err != nil {
+		return err
+	}
+
+	return m.savePlayList(playlist)
+}
+
 // Remove a playlist from the storage model
 func (m *MixTape) RemovePlayList(playlistID string) error {
 	_, err := strconv.ParseUint(playlistID, 10, 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Playlist ID %v is invalid.", playlistID))
+		return NewError(CodeInvalidValue, "Playlist ID %v is invalid.", playlistID)
 	}
 
-	_, ok := m.playListMap[playlistID]
-	if !ok {
-		return errors.New(fmt.Sprintf("Playlist ID %v does not exist.", playlistID))
+	_, err = m.GetPlayList(playlistID)
+	if err != nil {
+		return err
 	}
 
-	delete(m.playListMap, playlistID)
+	return m.deletePlayList(playlistID)
+}
+
+// Move a playlist to the end of the playlists
+func (m *MixTape) MovePlayListToEnd(playlistID string) error {
+	playlist, err := m.GetPlayList(playlistID)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	err = m.deletePlayList(playlistID)
+	if err != nil {
+		return err
+	}
+	return m.putPlayList(playlist)
 }
 
 // Add a song to a playlist in the storage model
 func (m *MixTape) AddSongToPlayList(playlistID, songID string) error {
 	_, err := strconv.ParseUint(playlistID, 10, 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Playlist ID %v is invalid.", playlistID))
+		return NewError(CodeInvalidValue, "Playlist ID %v is invalid.", playlistID)
 	}
 
-	_, ok := m.playListMap[playlistID]
-	if !ok {
-		return errors.New(fmt.Sprintf("Playlist ID %v does not exist.", playlistID))
+	playlist, err := m.GetPlayList(playlistID)
+	if err != nil {
+		return err
 	}
 
 	_, err = strconv.ParseUint(songID, 10, 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Song ID %v is invalid.", songID))
+		return NewError(CodeInvalidValue, "Song ID %v is invalid.", songID)
 	}
 
-	_, ok = m.songsMap[songID]
-	if !ok {
-		return errors.New(fmt.Sprintf("Song ID %v does not exist.", songID))
+	_, err = m.GetSong(songID)
+	if err != nil {
+		return err
 	}
 
-	m.playListMap[playlistID].SongIDs = append(m.playListMap[playlistID].SongIDs, songID)
+	playlist.SongIDs = append(playlist.SongIDs, songID)
 
-	return nil
+	return m.savePlayList(playlist)
+}
+
+// Insert a song in a playlist at the index, from 0 to the number of songs in the playlist
+func (m *MixTape) InsertSongInPlayList(playlistID string, index int, songID string) error {
+	playlist, err := m.playListForUpdate(playlistID)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index > len(playlist.SongIDs) {
+		return NewError(CodeOutOfRange, "Index %v is out of range for playlist ID %v.", index, playlistID)
+	}
+
+	playlist.SongIDs = append(playlist.SongIDs, "")
+	copy(playlist.SongIDs[index+1:], playlist.SongIDs[index:])
+	playlist.SongIDs[index] = songID
+
+	return m.savePlayList(playlist)
+}
+
+// Replace the song at the index in a playlist
+func (m *MixTape) ReplaceSongInPlayList(playlistID string, index int, songID string) error {
+	playlist, err := m.playListForUpdate(playlistID)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(playlist.SongIDs) {
+		return NewError(CodeOutOfRange, "Index %v is out of range for playlist ID %v.", index, playlistID)
+	}
+
+	playlist.SongIDs[index] = songID
+
+	return m.savePlayList(playlist)
+}
+
+// Remove the song at the index from a playlist
+func (m *MixTape) RemoveSongFromPlayList(playlistID string, index int) error {
+	playlist, err := m.playListForUpdate(playlistID)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(playlist.SongIDs) {
+		return NewError(CodeOutOfRange, "Index %v is out of range for playlist ID %v.", index, playlistID)
+	}
+
+	playlist.SongIDs = append(playlist.SongIDs[:index], playlist.SongIDs[index+1:]...)
+
+	return m.savePlayList(playlist)
+}
+
+// Move the song at the from index in a playlist to the to index
+func (m *MixTape) MoveSongInPlayList(playlistID string, from, to int) error {
+	playlist, err := m.playListForUpdate(playlistID)
+	if err != nil {
+		return err
+	}
+
+	if from < 0 || from >= len(playlist.SongIDs) {
+		return NewError(CodeOutOfRange, "Index %v is out of range for playlist ID %v.", from, playlistID)
+	}
+
+	if to < 0 || to >= len(playlist.SongIDs) {
+		return NewError(CodeOutOfRange, "Index %v is out of range for playlist ID %v.", to, playlistID)
+	}
+
+	songID := playlist.SongIDs[from]
+	if from < to {
+		copy(playlist.SongIDs[from:to], playlist.SongIDs[from+1:to+1])
+	} else {
+		copy(playlist.SongIDs[to+1:from+1], playlist.SongIDs[to:from])
+	}
+	playlist.SongIDs[to] = songID
+
+	return m.savePlayList(playlist)
+}
+
+// Get a copy of a playlist to update
+func (m *MixTape) playListForUpdate(playlistID string) (*PlayList, error) {
+	_, err := strconv.ParseUint(playlistID, 10, 32)
+	if err != nil {
+		return nil, NewError(CodeInvalidValue, "Playlist ID %v is invalid.", playlistID)
+	}
+
+	return m.GetPlayList(playlistID)
 }
 
 // Validate the input data and populate the storage model
 func (m *MixTape) populateStorageModel() error {
+	if m.storage == nil {
+		m.storage = NewMixTapeStorageModel()
+	}
+
 	err := m.validateAndAddUsers()
 	if err != nil {
 		return err
@@ -129,81 +659,220 @@ func (m *MixTape) populateStorageModel() error {
 }
 
 func (m *MixTape) validateAndAddUsers() error {
-	m.userMap = make(map[string]*User)
 	for _, user := range m.Users {
-		_, err := strconv.ParseUint(user.ID, 10, 32)
+		err := m.validateAndAddUser(user)
 		if err != nil {
-			return errors.New(fmt.Sprintf("User ID %v is invalid.", user.ID))
+			return err
 		}
+	}
 
-		if _, ok := m.userMap[user.ID]; ok {
-			return errors.New(fmt.Sprintf("Duplicate user ID %v.", user.ID))
-		}
+	return nil
+}
 
-		m.userMap[user.ID] = user
+func (m *MixTape) validateAndAddUser(user *User) error {
+	_, err := strconv.ParseUint(user.ID, 10, 32)
+	if err != nil {
+		return NewError(CodeInvalidValue, "User ID %v is invalid.", user.ID)
 	}
 
-	return nil
+	existing, err := m.storage.GetUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return NewError(CodeDuplicate, "Duplicate user ID %v.", user.ID)
+	}
+
+	return m.putUser(user)
 }
 
 func (m *MixTape) validateAndAddSongs() error {
-	m.songsMap = make(map[string]*Song)
 	for _, song := range m.Songs {
-		_, err := strconv.ParseUint(song.ID, 10, 32)
+		err := m.validateAndAddSong(song)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Song ID %v is invalid.", song.ID))
+			return err
 		}
+	}
 
-		if _, ok := m.songsMap[song.ID]; ok {
-			return errors.New(fmt.Sprintf("Duplicate song ID %v.", song.ID))
-		}
+	return nil
+}
 
-		m.songsMap[song.ID] = song
+func (m *MixTape) validateAndAddSong(song *Song) error {
+	_, err := strconv.ParseUint(song.ID, 10, 32)
+	if err != nil {
+		return NewError(CodeInvalidValue, "Song ID %v is invalid.", song.ID)
 	}
 
-	return nil
+	existing, err := m.storage.GetSong(song.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return NewError(CodeDuplicate, "Duplicate song ID %v.", song.ID)
+	}
+
+	return m.putSong(song)
 }
 
+//
+// An invalid playlist fails the ingestion in strict mode, otherwise it is dropped and
+// reported. In repair mode the song IDs of a playlist are repaired before it is validated.
+//
 func (m *MixTape) validateAndAddPlayLists() error {
-	m.playListMap = make(map[string]*PlayList)
+	m.issues = nil
 	for _, playlist := range m.PlayLists {
-		m.validateAndAddPlaylist(playlist)
+		err := m.ingestPlayList(playlist)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (m *MixTape) ingestPlayList(playlist *PlayList) error {
+	if m.ingestMode == IngestRepair {
+		m.repairPlayList(playlist)
+	}
+
+	err := m.validateAndAddPlaylist(playlist)
+	if err == nil {
+		return nil
+	}
+
+	return m.rejectPlayList(playlist, err)
+}
+
+// Fail the ingestion in strict mode, otherwise drop the playlist and report it
+func (m *MixTape) rejectPlayList(playlist *PlayList, err error) error {
+	if m.ingestMode == IngestStrict {
+		return NewError(Code(err), "Playlist ID %v is invalid. %v", playlist.ID, err)
+	}
+
+	m.addIssue(playlist.ID, Dropped, err)
+
+	return nil
+}
+
 func (m *MixTape) validateAndAddPlaylist(playlist *PlayList) error {
-	_, err := strconv.ParseUint(playlist.ID, 10, 32)
+	existing, err := m.storage.GetPlayList(playlist.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return NewError(CodeDuplicate, "Duplicate playlist ID %v.", playlist.ID)
+	}
+
+	return m.savePlayList(playlist)
+}
+
+//
+// Every change to a playlist is saved by savePlayList. The playlist is validated against
+// the playlist invariants before it is written to the storage model.
+//
+func (m *MixTape) savePlayList(playlist *PlayList) error {
+	err := m.validatePlaylist(playlist)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Playlist ID %v is invalid.", playlist.ID))
+		return err
 	}
 
-	if _, ok := m.playListMap[playlist.ID]; ok {
-		return errors.New(fmt.Sprintf("Duplicate playlist ID %v.", playlist.ID))
+	return m.putPlayList(playlist)
+}
+
+//
+// The playlist invariants. The user and songs exist, and the playlist has from 1 to 512
+// unique songs, as declared by the input schema.
+//
+func (m *MixTape) validatePlaylist(playlist *PlayList) error {
+	_, err := strconv.ParseUint(playlist.ID, 10, 32)
+	if err != nil {
+		return NewError(CodeInvalidValue, "Playlist ID %v is invalid.", playlist.ID)
 	}
 
 	_, err = strconv.ParseUint(playlist.UserID, 10, 32)
 	if err != nil {
-		return errors.New(fmt.Sprintf("User ID %v is invalid.", playlist.UserID))
+		return NewError(CodeInvalidValue, "User ID %v is invalid.", playlist.UserID)
 	}
 
-	if _, ok := m.userMap[playlist.UserID]; !ok {
-		return errors.New(fmt.Sprintf("The user ID %v does not exist.", playlist.UserID))
+	user, err := m.storage.GetUser(playlist.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return NewError(CodeNotFound, "The user ID %v does not exist.", playlist.UserID)
 	}
 
+	if len(playlist.SongIDs) == 0 {
+		return NewError(CodeLimit, "Playlist ID %v must have at least one song.", playlist.ID)
+	}
+
+	if len(playlist.SongIDs) > MaxPlayListSongs {
+		return NewError(CodeLimit, "Playlist ID %v cannot have more than %v songs.", playlist.ID, MaxPlayListSongs)
+	}
+
+	songIDs := make(map[string]bool, len(playlist.SongIDs))
 	for _, songID := range playlist.SongIDs {
+		if songIDs[songID] {
+			return NewError(CodeDuplicate, "Song ID %v is in playlist ID %v more than once.", songID, playlist.ID)
+		}
+		songIDs[songID] = true
+
 		_, err := strconv.ParseUint(songID, 10, 32)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Song ID %v is invalid.", songID))
+			return NewError(CodeInvalidValue, "Song ID %v is invalid.", songID)
 		}
 
-		if _, ok := m.songsMap[songID]; !ok {
-			return errors.New(fmt.Sprintf("The song ID %v does not exist.", songID))
+		song, err := m.storage.GetSong(songID)
+		if err != nil {
+			return err
+		}
+		if song == nil {
+			return NewError(CodeNotFound, "The song ID %v does not exist.", songID)
 		}
 	}
 
-	m.playListMap[playlist.ID] = playlist
+	return nil
+}
 
+//
+// The entities are written to the storage by the put and delete methods, which call the hooks
+// after the storage is changed. A transaction records the change for its commit instead.
+//
+
+func (m *MixTape) putUser(user *User) error {
+	return m.callHooks("users", user.ID, m.storage.PutUser(user))
+}
+
+func (m *MixTape) putSong(song *Song) error {
+	return m.callHooks("songs", song.ID, m.storage.PutSong(song))
+}
+
+func (m *MixTape) putPlayList(playlist *PlayList) error {
+	return m.callHooks("playlists", playlist.ID, m.storage.PutPlayList(playlist))
+}
+
+func (m *MixTape) deleteUser(userID string) error {
+	return m.callHooks("users", userID, m.storage.DeleteUser(userID))
+}
+
+func (m *MixTape) deleteSong(songID string) error {
+	return m.callHooks("songs", songID, m.storage.DeleteSong(songID))
+}
+
+func (m *MixTape) deletePlayList(playlistID string) error {
+	return m.callHooks("playlists", playlistID, m.storage.DeletePlayList(playlistID))
+}
+
+func (m *MixTape) callHooks(collection, id string, err error) error {
+	if err != nil {
+		return err
+	}
+	if m.parent != nil {
+		m.changed = append(m.changed, changedEntity{collection, id})
+		return nil
+	}
+	for _, hook := range m.hooks {
+		hook(collection, id)
+	}
 	return nil
 }
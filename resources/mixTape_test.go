@@ -0,0 +1,426 @@
+package resources
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTransactionHooks(t *testing.T) {
+	mixtape := NewMixTape()
+
+	var calls []string
+	record := func(name string) Hook {
+		return func(collection, id string) {
+			calls = append(calls, name+" "+collection+" "+id)
+		}
+	}
+
+	// Spare capacity in the hooks of the mixtape, which its transactions must not share
+	mixtape.hooks = make([]Hook, 0, 4)
+	mixtape.AddHook(record("mixtape"))
+
+	first := mixtape.Begin()
+	first.AddHook(record("first"))
+	second := mixtape.Begin()
+	second.AddHook(record("second"))
+
+	err := first.AddUser(&User{ID: "1", Name: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = second.AddUser(&User{ID: "2", Name: "Ben"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mixtape.AddUser(&User{ID: "3", Name: "Cy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(calls, []string{"mixtape users 3"}) {
+		t.Errorf("expected only the hook call of the mixtape before the commit, got %v", calls)
+	}
+
+	// The second transaction is discarded, its change never calls the hooks
+	calls = nil
+	err = first.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first users 1", "mixtape users 1"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the hook calls %v, got %v", expected, calls)
+	}
+}
+
+func TestNestedTransactionHooks(t *testing.T) {
+	mixtape := NewMixTape()
+
+	var calls []string
+	mixtape.AddHook(func(collection, id string) {
+		calls = append(calls, collection+" "+id)
+	})
+
+	outer := mixtape.Begin()
+	inner := outer.Begin()
+	err := inner.AddUser(&User{ID: "1", Name: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inner.AddSong(&Song{ID: "2", Artist: "Artist", Title: "Title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = inner.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("expected no hook calls before the outer commit, got %v", calls)
+	}
+
+	err = outer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"users 1", "songs 2"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the hook calls %v, got %v", expected, calls)
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	parsers := map[string]func(name string) error{
+		"sort mode": func(name string) error {
+			_, err := ParseSortMode(name)
+			return err
+		},
+		"ingest mode": func(name string) error {
+			_, err := ParseIngestMode(name)
+			return err
+		},
+		"reference policy": func(name string) error {
+			_, err := ParseReferencePolicy(name)
+			return err
+		},
+	}
+	valid := map[string][]string{
+		"sort mode":        {"insertion", "id", "user"},
+		"ingest mode":      {"lenient", "strict", "repair"},
+		"reference policy": {"reject", "cascade", "strip"},
+	}
+
+	for kind, parse := range parsers {
+		for _, name := range valid[kind] {
+			if err := parse(name); err != nil {
+				t.Errorf("%v %v: %v", kind, name, err)
+			}
+		}
+		for _, name := range []string{"", "Strict", "other"} {
+			if err := parse(name); Code(err) != CodeInvalidValue {
+				t.Errorf("%v %q: expected an invalid value error, got %v", kind, name, err)
+			}
+		}
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	mixtape := NewMixTape()
+	err := mixtape.AddUser(&User{ID: "1", Name: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//
+	// The changes of a transaction are only seen by the transaction until it is committed,
+	// a discarded transaction leaves the mixtape unchanged
+	//
+
+	discarded := mixtape.Begin()
+	err = discarded.RemoveUser("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = discarded.GetUser("1"); Code(err) != CodeNotFound {
+		t.Errorf("expected the transaction to see the removal, got %v", err)
+	}
+
+	tx := mixtape.Begin()
+	err = tx.AddUser(&User{ID: "2", Name: "Ben"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mixtape.GetUser("2"); Code(err) != CodeNotFound {
+		t.Errorf("expected the mixtape not to see the change before the commit, got %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := mixtape.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Errorf("expected the users [1 2] after the commit, got %v", ids)
+	}
+}
+
+// A mixtape of two users, songs 1 to the number of songs, playlist 1 of user 1 with songs 1, 2
+// and 3, and playlist 2 of user 2 with song 1
+func newTestMixTape(t *testing.T, songs int) *MixTape {
+	mixtape := NewMixTape()
+	for _, user := range []*User{{ID: "1", Name: "Ana"}, {ID: "2", Name: "Ben"}} {
+		err := mixtape.AddUser(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= songs; id++ {
+		err := mixtape.AddSong(&Song{ID: strconv.Itoa(id), Artist: "Artist", Title: "Title"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, playlist := range []*PlayList{{ID: "1", UserID: "1", SongIDs: []string{"1", "2", "3"}}, {ID: "2", UserID: "2", SongIDs: []string{"1"}}} {
+		err := mixtape.AddPlayList(playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mixtape
+}
+
+func TestPlayListSongPositions(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(m *MixTape) error
+		code     ErrorCode
+		expected []string
+	}{
+		{"insert at the start", func(m *MixTape) error { return m.InsertSongInPlayList("1", 0, "4") }, "", []string{"4", "1", "2", "3"}},
+		{"insert in the middle", func(m *MixTape) error { return m.InsertSongInPlayList("1", 2, "4") }, "", []string{"1", "2", "4", "3"}},
+		{"insert at the end", func(m *MixTape) error { return m.InsertSongInPlayList("1", 3, "4") }, "", []string{"1", "2", "3", "4"}},
+		{"insert past the end", func(m *MixTape) error { return m.InsertSongInPlayList("1", 4, "4") }, CodeOutOfRange, nil},
+		{"insert before the start", func(m *MixTape) error { return m.InsertSongInPlayList("1", -1, "4") }, CodeOutOfRange, nil},
+		{"insert a song twice", func(m *MixTape) error { return m.InsertSongInPlayList("1", 0, "3") }, CodeDuplicate, nil},
+		{"insert a missing song", func(m *MixTape) error { return m.InsertSongInPlayList("1", 0, "9") }, CodeNotFound, nil},
+		{"remove the first song", func(m *MixTape) error { return m.RemoveSongFromPlayList("1", 0) }, "", []string{"2", "3"}},
+		{"remove the last song", func(m *MixTape) error { return m.RemoveSongFromPlayList("1", 2) }, "", []string{"1", "2"}},
+		{"remove past the end", func(m *MixTape) error { return m.RemoveSongFromPlayList("1", 3) }, CodeOutOfRange, nil},
+		{"replace a song", func(m *MixTape) error { return m.ReplaceSongInPlayList("1", 1, "4") }, "", []string{"1", "4", "3"}},
+		{"replace a song with itself", func(m *MixTape) error { return m.ReplaceSongInPlayList("1", 1, "2") }, "", []string{"1", "2", "3"}},
+		{"replace a song with another song of the playlist", func(m *MixTape) error { return m.ReplaceSongInPlayList("1", 1, "3") }, CodeDuplicate, nil},
+		{"replace past the end", func(m *MixTape) error { return m.ReplaceSongInPlayList("1", 3, "4") }, CodeOutOfRange, nil},
+		{"move a song forward", func(m *MixTape) error { return m.MoveSongInPlayList("1", 0, 2) }, "", []string{"2", "3", "1"}},
+		{"move a song backward", func(m *MixTape) error { return m.MoveSongInPlayList("1", 2, 0) }, "", []string{"3", "1", "2"}},
+		{"move a song to its position", func(m *MixTape) error { return m.MoveSongInPlayList("1", 1, 1) }, "", []string{"1", "2", "3"}},
+		{"move a song past the end", func(m *MixTape) error { return m.MoveSongInPlayList("1", 0, 3) }, CodeOutOfRange, nil},
+		{"move a song from past the end", func(m *MixTape) error { return m.MoveSongInPlayList("1", 3, 0) }, CodeOutOfRange, nil},
+		{"invalid playlist ID", func(m *MixTape) error { return m.RemoveSongFromPlayList("x", 0) }, CodeInvalidValue, nil},
+		{"missing playlist", func(m *MixTape) error { return m.InsertSongInPlayList("9", 0, "4") }, CodeNotFound, nil},
+	}
+
+	for _, test := range tests {
+		mixtape := newTestMixTape(t, 4)
+		err := test.change(mixtape)
+		if len(test.code) != 0 {
+			if Code(err) != test.code {
+				t.Errorf("%v: expected the code %v, got %v", test.name, test.code, err)
+			}
+			test.expected = []string{"1", "2", "3"}
+		} else if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		// A failed change leaves the playlist unchanged
+		playlist, err := mixtape.GetPlayList("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(playlist.SongIDs, test.expected) {
+			t.Errorf("%v: expected the songs %v, got %v", test.name, test.expected, playlist.SongIDs)
+		}
+	}
+}
+
+func TestPlayListInvariants(t *testing.T) {
+	songIDs := func(from, to int) []string {
+		ids := []string{}
+		for id := from; id <= to; id++ {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *MixTape) error
+		code   ErrorCode
+	}{
+		{"add a song", func(m *MixTape) error { return m.AddSongToPlayList("1", "4") }, ""},
+		{"add a song twice", func(m *MixTape) error { return m.AddSongToPlayList("1", "2") }, CodeDuplicate},
+		{"add a missing song", func(m *MixTape) error { return m.AddSongToPlayList("1", "999") }, CodeNotFound},
+		{"add an invalid song ID", func(m *MixTape) error { return m.AddSongToPlayList("1", "x") }, CodeInvalidValue},
+		{"add the 512th song", func(m *MixTape) error {
+			return m.ReplacePlayList(&PlayList{ID: "1", UserID: "1", SongIDs: songIDs(1, MaxPlayListSongs)})
+		}, ""},
+		{"add the 513th song", func(m *MixTape) error {
+			err := m.ReplacePlayList(&PlayList{ID: "1", UserID: "1", SongIDs: songIDs(1, MaxPlayListSongs)})
+			if err != nil {
+				return err
+			}
+			return m.AddSongToPlayList("1", strconv.Itoa(MaxPlayListSongs+1))
+		}, CodeLimit},
+		{"insert the 513th song", func(m *MixTape) error {
+			err := m.ReplacePlayList(&PlayList{ID: "1", UserID: "1", SongIDs: songIDs(1, MaxPlayListSongs)})
+			if err != nil {
+				return err
+			}
+			return m.InsertSongInPlayList("1", 0, strconv.Itoa(MaxPlayListSongs+1))
+		}, CodeLimit},
+		{"add a playlist of 513 songs", func(m *MixTape) error {
+			return m.AddPlayList(&PlayList{ID: "3", UserID: "1", SongIDs: songIDs(1, MaxPlayListSongs+1)})
+		}, CodeLimit},
+		{"add a playlist with a song twice", func(m *MixTape) error {
+			return m.AddPlayList(&PlayList{ID: "3", UserID: "1", SongIDs: []string{"1", "2", "1"}})
+		}, CodeDuplicate},
+		{"add a playlist without songs", func(m *MixTape) error {
+			return m.AddPlayList(&PlayList{ID: "3", UserID: "1", SongIDs: []string{}})
+		}, CodeLimit},
+		{"add a playlist of a missing user", func(m *MixTape) error {
+			return m.AddPlayList(&PlayList{ID: "3", UserID: "9", SongIDs: []string{"1"}})
+		}, CodeNotFound},
+		{"add an existing playlist", func(m *MixTape) error {
+			return m.AddPlayList(&PlayList{ID: "2", UserID: "1", SongIDs: []string{"1"}})
+		}, CodeDuplicate},
+		{"replace the songs with a song twice", func(m *MixTape) error {
+			return m.ReplacePlayList(&PlayList{ID: "2", UserID: "2", SongIDs: []string{"4", "4"}})
+		}, CodeDuplicate},
+		{"remove the only song", func(m *MixTape) error { return m.RemoveSongFromPlayList("2", 0) }, CodeLimit},
+	}
+
+	for _, test := range tests {
+		mixtape := newTestMixTape(t, MaxPlayListSongs+1)
+		err := test.change(mixtape)
+		if len(test.code) == 0 && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if len(test.code) != 0 && Code(err) != test.code {
+			t.Errorf("%v: expected the code %v, got %v", test.name, test.code, err)
+		}
+
+		//
+		// Every playlist in the mixtape keeps the invariants
+		//
+		err = mixtape.ForEachPlayList(func(playlist *PlayList) error {
+			return mixtape.validatePlaylist(playlist)
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+	}
+}
+
+// The playlists of the mixtape, as IDs and song IDs
+func playListSongs(t *testing.T, mixtape *MixTape) map[string][]string {
+	playlists, err := mixtape.GetPlayLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	songs := map[string][]string{}
+	for _, playlist := range playlists {
+		songs[playlist.ID] = playlist.SongIDs
+	}
+	return songs
+}
+
+func TestReferencePolicies(t *testing.T) {
+	unchanged := map[string][]string{"1": {"1", "2", "3"}, "2": {"1"}}
+
+	tests := []struct {
+		policy    ReferencePolicy
+		remove    func(m *MixTape) error
+		code      ErrorCode
+		playlists map[string][]string
+	}{
+		{RejectReferences, func(m *MixTape) error { return m.RemoveUser("1") }, CodeReferenced, unchanged},
+		{RejectReferences, func(m *MixTape) error { return m.RemoveSong("1") }, CodeReferenced, unchanged},
+		{RejectReferences, func(m *MixTape) error { return m.RemoveSong("4") }, "", unchanged},
+		{CascadeReferences, func(m *MixTape) error { return m.RemoveUser("1") }, "", map[string][]string{"2": {"1"}}},
+		{CascadeReferences, func(m *MixTape) error { return m.RemoveSong("1") }, "", map[string][]string{}},
+		{CascadeReferences, func(m *MixTape) error { return m.RemoveSong("2") }, "", map[string][]string{"2": {"1"}}},
+		// A playlist cannot exist without its user, a playlist without songs is removed
+		{StripReferences, func(m *MixTape) error { return m.RemoveUser("1") }, "", map[string][]string{"2": {"1"}}},
+		{StripReferences, func(m *MixTape) error { return m.RemoveSong("1") }, "", map[string][]string{"1": {"2", "3"}}},
+		{StripReferences, func(m *MixTape) error { return m.RemoveSong("2") }, "", map[string][]string{"1": {"1", "3"}, "2": {"1"}}},
+		{StripReferences, func(m *MixTape) error { return m.RemoveSong("9") }, CodeNotFound, unchanged},
+	}
+
+	for i, test := range tests {
+		mixtape := newTestMixTape(t, 4)
+		mixtape.SetReferencePolicy(test.policy)
+
+		err := test.remove(mixtape)
+		if len(test.code) == 0 && err != nil {
+			t.Errorf("test %v, policy %v: %v", i, test.policy, err)
+		}
+		if len(test.code) != 0 && Code(err) != test.code {
+			t.Errorf("test %v, policy %v: expected the code %v, got %v", i, test.policy, test.code, err)
+		}
+
+		if actual := playListSongs(t, mixtape); !reflect.DeepEqual(actual, test.playlists) {
+			t.Errorf("test %v, policy %v: expected the playlists %v, got %v", i, test.policy, test.playlists, actual)
+		}
+	}
+}
+
+func TestUserAndSongChanges(t *testing.T) {
+	mixtape := newTestMixTape(t, 4)
+
+	tests := []struct {
+		name   string
+		change func() error
+		code   ErrorCode
+	}{
+		{"add a user", func() error { return mixtape.AddUser(&User{ID: "3", Name: "Cy"}) }, ""},
+		{"add an existing user", func() error { return mixtape.AddUser(&User{ID: "1", Name: "Cy"}) }, CodeDuplicate},
+		{"rename a user", func() error { return mixtape.ReplaceUser(&User{ID: "1", Name: "Anna"}) }, ""},
+		{"rename a missing user", func() error { return mixtape.ReplaceUser(&User{ID: "9", Name: "Anna"}) }, CodeNotFound},
+		{"remove a user without playlists", func() error { return mixtape.RemoveUser("3") }, ""},
+		{"remove a missing user", func() error { return mixtape.RemoveUser("3") }, CodeNotFound},
+		{"add a song", func() error { return mixtape.AddSong(&Song{ID: "5", Artist: "Zedd", Title: "Clarity"}) }, ""},
+		{"add an existing song", func() error { return mixtape.AddSong(&Song{ID: "1", Artist: "Zedd", Title: "Clarity"}) }, CodeDuplicate},
+		{"edit a song", func() error { return mixtape.ReplaceSong(&Song{ID: "1", Artist: "Zedd", Title: "Stay"}) }, ""},
+		{"edit a missing song", func() error { return mixtape.ReplaceSong(&Song{ID: "9", Artist: "Zedd", Title: "Stay"}) }, CodeNotFound},
+		{"remove a song", func() error { return mixtape.RemoveSong("5") }, ""},
+	}
+
+	for _, test := range tests {
+		err := test.change()
+		if len(test.code) == 0 && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if len(test.code) != 0 && Code(err) != test.code {
+			t.Errorf("%v: expected the code %v, got %v", test.name, test.code, err)
+		}
+	}
+
+	user, err := mixtape.GetUser("1")
+	if err != nil || user.Name != "Anna" {
+		t.Errorf("expected the renamed user, got %v %v", user, err)
+	}
+	song, err := mixtape.GetSong("1")
+	if err != nil || song.Title != "Stay" {
+		t.Errorf("expected the edited song, got %v %v", song, err)
+	}
+}
@@ -0,0 +1,245 @@
+package resources
+
+import (
+	"sort"
+)
+
+// The overlay storage is the storage of a transaction. The changes are kept in memory over
+// the storage of the mixtape, which is unchanged until the changes are committed, so a
+// transaction costs the size of its changes rather than a copy of the storage.
+type overlayStorage struct {
+	base      Storage
+	users     *overlayCollection
+	songs     *overlayCollection
+	playlists *overlayCollection
+}
+
+// A changed entity. An entity that is new, or deleted and put again, is appended after the
+// entities of the base storage.
+type overlayEntry struct {
+	value    interface{}
+	deleted  bool
+	appended bool
+	order    uint64
+}
+
+type overlayCollection struct {
+	ops     collectionOps
+	entries map[string]*overlayEntry
+	next    uint64
+}
+
+func newOverlayStorage(base Storage) *overlayStorage {
+	return &overlayStorage{
+		base:      base,
+		users:     newOverlayCollection(userOps),
+		songs:     newOverlayCollection(songOps),
+		playlists: newOverlayCollection(playListOps),
+	}
+}
+
+func newOverlayCollection(ops collectionOps) *overlayCollection {
+	return &overlayCollection{
+		ops:     ops,
+		entries: make(map[string]*overlayEntry),
+	}
+}
+
+func (c *overlayCollection) get(base Storage, id string) (interface{}, error) {
+	if entry, ok := c.entries[id]; ok {
+		if entry.deleted {
+			return nil, nil
+		}
+		return entry.value, nil
+	}
+	return c.ops.get(base, id)
+}
+
+func (c *overlayCollection) put(base Storage, id string, value interface{}) error {
+	entry, ok := c.entries[id]
+	if ok && !entry.deleted {
+		entry.value = value
+		return nil
+	}
+
+	if !ok {
+		current, err := c.ops.get(base, id)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			c.entries[id] = &overlayEntry{value: value}
+			return nil
+		}
+		entry = &overlayEntry{}
+		c.entries[id] = entry
+	}
+
+	entry.value = value
+	entry.deleted = false
+	entry.appended = true
+	entry.order = c.next
+	c.next++
+
+	return nil
+}
+
+func (c *overlayCollection) delete(id string) {
+	c.entries[id] = &overlayEntry{deleted: true}
+}
+
+func (c *overlayCollection) forEach(base Storage, fn func(id string, value interface{}) error) error {
+	err := c.ops.forEach(base, func(id string, value interface{}) error {
+		entry, ok := c.entries[id]
+		if !ok {
+			return fn(id, value)
+		}
+		if entry.deleted || entry.appended {
+			return nil
+		}
+		return fn(id, entry.value)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range c.appendedIDs() {
+		err = fn(id, c.entries[id].value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *overlayCollection) appendedIDs() []string {
+	ids := []string{}
+	for id, entry := range c.entries {
+		if entry.appended && !entry.deleted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return c.entries[ids[i]].order < c.entries[ids[j]].order
+	})
+	return ids
+}
+
+// Write the changes to the storage. The deleted entities, and the entities that are deleted
+// and put again, are deleted first, then the replaced entities are put in place, and the
+// appended entities are put at the end, in order.
+func (c *overlayCollection) commit(storage Storage) error {
+	for id, entry := range c.entries {
+		if entry.deleted || entry.appended {
+			err := c.ops.delete(storage, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range c.entries {
+		if !entry.deleted && !entry.appended {
+			err := c.ops.put(storage, entry.value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range c.appendedIDs() {
+		err := c.ops.put(storage, c.entries[id].value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write the changes to the base storage, atomically when the base storage supports it
+func (s *overlayStorage) commit() error {
+	return s.base.Update(func(storage Storage) error {
+		for _, collection := range []*overlayCollection{s.users, s.songs, s.playlists} {
+			err := collection.commit(storage)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *overlayStorage) GetUser(userID string) (*User, error) {
+	value, err := s.users.get(s.base, userID)
+	if value == nil {
+		return nil, err
+	}
+	return value.(*User), nil
+}
+
+func (s *overlayStorage) PutUser(user *User) error {
+	return s.users.put(s.base, user.ID, user)
+}
+
+func (s *overlayStorage) DeleteUser(userID string) error {
+	s.users.delete(userID)
+	return nil
+}
+
+func (s *overlayStorage) ForEachUser(fn func(user *User) error) error {
+	return s.users.forEach(s.base, func(id string, value interface{}) error {
+		return fn(value.(*User))
+	})
+}
+
+func (s *overlayStorage) GetSong(songID string) (*Song, error) {
+	value, err := s.songs.get(s.base, songID)
+	if value == nil {
+		return nil, err
+	}
+	return value.(*Song), nil
+}
+
+func (s *overlayStorage) PutSong(song *Song) error {
+	return s.songs.put(s.base, song.ID, song)
+}
+
+func (s *overlayStorage) DeleteSong(songID string) error {
+	s.songs.delete(songID)
+	return nil
+}
+
+func (s *overlayStorage) ForEachSong(fn func(song *Song) error) error {
+	return s.songs.forEach(s.base, func(id string, value interface{}) error {
+		return fn(value.(*Song))
+	})
+}
+
+func (s *overlayStorage) GetPlayList(playlistID string) (*PlayList, error) {
+	value, err := s.playlists.get(s.base, playlistID)
+	if value == nil {
+		return nil, err
+	}
+	return value.(*PlayList), nil
+}
+
+func (s *overlayStorage) PutPlayList(playlist *PlayList) error {
+	return s.playlists.put(s.base, playlist.ID, playlist)
+}
+
+func (s *overlayStorage) DeletePlayList(playlistID string) error {
+	s.playlists.delete(playlistID)
+	return nil
+}
+
+func (s *overlayStorage) ForEachPlayList(fn func(playlist *PlayList) error) error {
+	return s.playlists.forEach(s.base, func(id string, value interface{}) error {
+		return fn(value.(*PlayList))
+	})
+}
+
+// The changes of a transaction are in memory, fn is run with the overlay
+func (s *overlayStorage) Update(fn func(storage Storage) error) error {
+	return fn(s)
+}
@@ -1,7 +1,20 @@
 package resources
 
+// The maximum number of songs in a playlist
+const MaxPlayListSongs = 512
+
 type PlayList struct {
 	ID      string   `json:"id"`
 	UserID  string   `json:"user_id"`
 	SongIDs []string `json:"song_ids"`
 }
+
+func (p *PlayList) copy() *PlayList {
+	songIDs := make([]string, len(p.SongIDs))
+	copy(songIDs, p.SongIDs)
+	return &PlayList{
+		ID:      p.ID,
+		UserID:  p.UserID,
+		SongIDs: songIDs,
+	}
+}
@@ -0,0 +1,27 @@
+package resources
+
+// The reference policy controls what happens to the playlists of a user or song that is removed
+type ReferencePolicy int
+
+const (
+	// The user or song cannot be removed while a playlist refers to it
+	RejectReferences ReferencePolicy = iota
+	// The playlists that refer to the user or song are removed
+	CascadeReferences
+	// The song is removed from the playlists that refer to it, a playlist without songs is removed.
+	// The playlists of a user are removed, a playlist cannot exist without its user.
+	StripReferences
+)
+
+// Parse the command line name of a reference policy
+func ParseReferencePolicy(name string) (ReferencePolicy, error) {
+	switch name {
+	case "reject":
+		return RejectReferences, nil
+	case "cascade":
+		return CascadeReferences, nil
+	case "strip":
+		return StripReferences, nil
+	}
+	return RejectReferences, NewError(CodeInvalidValue, "Unknown reference policy %v.", name)
+}
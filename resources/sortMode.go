@@ -0,0 +1,75 @@
+package resources
+
+import (
+	"sort"
+	"strconv"
+)
+
+// The sort mode is the order of the users, songs and playlists in the output
+type SortMode int
+
+const (
+	// The input order, new entities are added at the end
+	SortByInsertion SortMode = iota
+	// The numeric ID order
+	SortByID
+	// The playlists are ordered by user ID then playlist ID, the users and songs by ID
+	SortByUser
+)
+
+// Parse the command line name of a sort mode
+func ParseSortMode(name string) (SortMode, error) {
+	switch name {
+	case "insertion":
+		return SortByInsertion, nil
+	case "id":
+		return SortByID, nil
+	case "user":
+		return SortByUser, nil
+	}
+	return SortByInsertion, NewError(CodeInvalidValue, "Unknown sort mode %v.", name)
+}
+
+func sortUsers(users []*User, mode SortMode) {
+	if mode == SortByInsertion {
+		return
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		return lessID(users[i].ID, users[j].ID)
+	})
+}
+
+func sortSongs(songs []*Song, mode SortMode) {
+	if mode == SortByInsertion {
+		return
+	}
+	sort.SliceStable(songs, func(i, j int) bool {
+		return lessID(songs[i].ID, songs[j].ID)
+	})
+}
+
+func sortPlayLists(playlists []*PlayList, mode SortMode) {
+	switch mode {
+	case SortByID:
+		sort.SliceStable(playlists, func(i, j int) bool {
+			return lessID(playlists[i].ID, playlists[j].ID)
+		})
+	case SortByUser:
+		sort.SliceStable(playlists, func(i, j int) bool {
+			if playlists[i].UserID != playlists[j].UserID {
+				return lessID(playlists[i].UserID, playlists[j].UserID)
+			}
+			return lessID(playlists[i].ID, playlists[j].ID)
+		})
+	}
+}
+
+// Compare IDs numerically, IDs that are not numbers are compared as strings
+func lessID(a, b string) bool {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
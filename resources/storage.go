@@ -0,0 +1,98 @@
+package resources
+
+// The storage of the users, songs and playlists of a mixtape. A get returns nil, without an
+// error, when the entity does not exist, and a delete of an entity that does not exist does
+// nothing. The entities are iterated in insertion order, an entity keeps its position when
+// it is replaced, and moves to the end when it is deleted and put again. The entities that
+// are put and returned are never modified by the mixtape.
+type Storage interface {
+	GetUser(userID string) (*User, error)
+	PutUser(user *User) error
+	DeleteUser(userID string) error
+	ForEachUser(fn func(user *User) error) error
+
+	GetSong(songID string) (*Song, error)
+	PutSong(song *Song) error
+	DeleteSong(songID string) error
+	ForEachSong(fn func(song *Song) error) error
+
+	GetPlayList(playlistID string) (*PlayList, error)
+	PutPlayList(playlist *PlayList) error
+	DeletePlayList(playlistID string) error
+	ForEachPlayList(fn func(playlist *PlayList) error) error
+
+	// Run fn with the storage, the changes made by fn are written atomically when the
+	// storage supports it
+	Update(fn func(storage Storage) error) error
+}
+
+// The operations of a collection of the storage, with the entities as interface{} values
+type collectionOps struct {
+	get     func(storage Storage, id string) (interface{}, error)
+	put     func(storage Storage, value interface{}) error
+	delete  func(storage Storage, id string) error
+	forEach func(storage Storage, fn func(id string, value interface{}) error) error
+}
+
+var userOps = collectionOps{
+	get: func(storage Storage, id string) (interface{}, error) {
+		user, err := storage.GetUser(id)
+		if user == nil {
+			return nil, err
+		}
+		return user, err
+	},
+	put: func(storage Storage, value interface{}) error {
+		return storage.PutUser(value.(*User))
+	},
+	delete: func(storage Storage, id string) error {
+		return storage.DeleteUser(id)
+	},
+	forEach: func(storage Storage, fn func(id string, value interface{}) error) error {
+		return storage.ForEachUser(func(user *User) error {
+			return fn(user.ID, user)
+		})
+	},
+}
+
+var songOps = collectionOps{
+	get: func(storage Storage, id string) (interface{}, error) {
+		song, err := storage.GetSong(id)
+		if song == nil {
+			return nil, err
+		}
+		return song, err
+	},
+	put: func(storage Storage, value interface{}) error {
+		return storage.PutSong(value.(*Song))
+	},
+	delete: func(storage Storage, id string) error {
+		return storage.DeleteSong(id)
+	},
+	forEach: func(storage Storage, fn func(id string, value interface{}) error) error {
+		return storage.ForEachSong(func(song *Song) error {
+			return fn(song.ID, song)
+		})
+	},
+}
+
+var playListOps = collectionOps{
+	get: func(storage Storage, id string) (interface{}, error) {
+		playlist, err := storage.GetPlayList(id)
+		if playlist == nil {
+			return nil, err
+		}
+		return playlist, err
+	},
+	put: func(storage Storage, value interface{}) error {
+		return storage.PutPlayList(value.(*PlayList))
+	},
+	delete: func(storage Storage, id string) error {
+		return storage.DeletePlayList(id)
+	},
+	forEach: func(storage Storage, fn func(id string, value interface{}) error) error {
+		return storage.ForEachPlayList(func(playlist *PlayList) error {
+			return fn(playlist.ID, playlist)
+		})
+	},
+}
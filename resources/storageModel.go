@@ -0,0 +1,145 @@
+package resources
+
+import (
+	"sort"
+)
+
+// The storage model is the in-memory key/value storage
+type MixTapeStorageModel struct {
+	userMap       map[string]*User
+	songsMap      map[string]*Song
+	playListMap   map[string]*PlayList
+	userOrder     *insertionOrder
+	songOrder     *insertionOrder
+	playListOrder *insertionOrder
+}
+
+func NewMixTapeStorageModel() *MixTapeStorageModel {
+	return &MixTapeStorageModel{
+		userMap:       make(map[string]*User),
+		songsMap:      make(map[string]*Song),
+		playListMap:   make(map[string]*PlayList),
+		userOrder:     newInsertionOrder(),
+		songOrder:     newInsertionOrder(),
+		playListOrder: newInsertionOrder(),
+	}
+}
+
+func (s *MixTapeStorageModel) GetUser(userID string) (*User, error) {
+	return s.userMap[userID], nil
+}
+
+func (s *MixTapeStorageModel) PutUser(user *User) error {
+	s.userMap[user.ID] = user
+	s.userOrder.add(user.ID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) DeleteUser(userID string) error {
+	delete(s.userMap, userID)
+	s.userOrder.remove(userID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) ForEachUser(fn func(user *User) error) error {
+	for _, id := range s.userOrder.ids() {
+		err := fn(s.userMap[id])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MixTapeStorageModel) GetSong(songID string) (*Song, error) {
+	return s.songsMap[songID], nil
+}
+
+func (s *MixTapeStorageModel) PutSong(song *Song) error {
+	s.songsMap[song.ID] = song
+	s.songOrder.add(song.ID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) DeleteSong(songID string) error {
+	delete(s.songsMap, songID)
+	s.songOrder.remove(songID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) ForEachSong(fn func(song *Song) error) error {
+	for _, id := range s.songOrder.ids() {
+		err := fn(s.songsMap[id])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MixTapeStorageModel) GetPlayList(playlistID string) (*PlayList, error) {
+	return s.playListMap[playlistID], nil
+}
+
+func (s *MixTapeStorageModel) PutPlayList(playlist *PlayList) error {
+	s.playListMap[playlist.ID] = playlist
+	s.playListOrder.add(playlist.ID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) DeletePlayList(playlistID string) error {
+	delete(s.playListMap, playlistID)
+	s.playListOrder.remove(playlistID)
+	return nil
+}
+
+func (s *MixTapeStorageModel) ForEachPlayList(fn func(playlist *PlayList) error) error {
+	for _, id := range s.playListOrder.ids() {
+		err := fn(s.playListMap[id])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The changes of the in-memory storage cannot fail, fn is run with the storage
+func (s *MixTapeStorageModel) Update(fn func(storage Storage) error) error {
+	return fn(s)
+}
+
+// The insertion order of the IDs in a map of the storage model. An ID keeps its position
+// when its value is replaced, and moves to the end when it is removed and added again.
+type insertionOrder struct {
+	seq  map[string]uint64
+	next uint64
+}
+
+func newInsertionOrder() *insertionOrder {
+	return &insertionOrder{
+		seq: make(map[string]uint64),
+	}
+}
+
+func (o *insertionOrder) add(id string) {
+	if _, ok := o.seq[id]; ok {
+		return
+	}
+	o.seq[id] = o.next
+	o.next++
+}
+
+func (o *insertionOrder) remove(id string) {
+	delete(o.seq, id)
+}
+
+func (o *insertionOrder) ids() []string {
+	ids := make([]string, 0, len(o.seq))
+	for id := range o.seq {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return o.seq[ids[i]] < o.seq[ids[j]]
+	})
+	return ids
+}
@@ -0,0 +1,573 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"highspot/data"
+	"highspot/data/validation"
+	"highspot/resources"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// The API server serves a mixtape as REST resources. The users, songs and playlists are
+// collections, with GET and POST on the collection, and GET, PUT and DELETE on an entity, and
+// the songs of a playlist are the /playlists/{id}/songs resource. The request bodies are
+// validated against the schemas of the changes file values, and the changes are made by the
+// mixtape methods, so a request has the same checks as a change of the changes file.
+//
+// Each change is made in a transaction. The output is written with the change before the
+// transaction is committed, so the output and the served mixtape are changed together, or
+// not at all when the output cannot be written.
+//
+// The ETag of a response is the version of the whole mixtape, which is changed by each change.
+// A change with an If-Match header is made only when the mixtape has not changed since the
+// client read it, so concurrent clients cannot overwrite each other's changes. The If-Match
+// header is required by a JSON patch or a merge patch of the mixtape document.
+//
+
+// The media types of a JSON patch and of a JSON merge patch
+const (
+	JSONPatchType  = "application/json-patch+json"
+	MergePatchType = "application/merge-patch+json"
+)
+
+// The changes format of each patch media type
+var patchFormats = map[string]data.ChangesFormat{
+	JSONPatchType:  data.JSONPatchFormat,
+	MergePatchType: data.MergePatchFormat,
+}
+
+// The Accept-Patch header of the mixtape document
+var acceptPatch = JSONPatchType + ", " + MergePatchType
+
+// A collection of the API, the functions adapt the methods of the mixtape to its entity type
+type apiCollection struct {
+	kind    string
+	schema  string
+	new     func() interface{}
+	id      func(value interface{}) string
+	list    func(mixtape *resources.MixTape) (interface{}, error)
+	get     func(mixtape *resources.MixTape, id string) (interface{}, error)
+	add     func(mixtape *resources.MixTape, value interface{}) error
+	replace func(mixtape *resources.MixTape, value interface{}) error
+	remove  func(mixtape *resources.MixTape, id string) error
+}
+
+var apiCollections = map[string]*apiCollection{
+	"users": {
+		kind:   "user",
+		schema: validation.PatchUserSchema,
+		new:    func() interface{} { return &resources.User{} },
+		id:     func(value interface{}) string { return value.(*resources.User).ID },
+		list: func(mixtape *resources.MixTape) (interface{}, error) {
+			return mixtape.GetUsers()
+		},
+		get: func(mixtape *resources.MixTape, id string) (interface{}, error) {
+			return mixtape.GetUser(id)
+		},
+		add: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.AddUser(value.(*resources.User))
+		},
+		replace: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.ReplaceUser(value.(*resources.User))
+		},
+		remove: func(mixtape *resources.MixTape, id string) error {
+			return mixtape.RemoveUser(id)
+		},
+	},
+	"songs": {
+		kind:   "song",
+		schema: validation.PatchSongSchema,
+		new:    func() interface{} { return &resources.Song{} },
+		id:     func(value interface{}) string { return value.(*resources.Song).ID },
+		list: func(mixtape *resources.MixTape) (interface{}, error) {
+			return mixtape.GetSongs()
+		},
+		get: func(mixtape *resources.MixTape, id string) (interface{}, error) {
+			return mixtape.GetSong(id)
+		},
+		add: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.AddSong(value.(*resources.Song))
+		},
+		replace: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.ReplaceSong(value.(*resources.Song))
+		},
+		remove: func(mixtape *resources.MixTape, id string) error {
+			return mixtape.RemoveSong(id)
+		},
+	},
+	"playlists": {
+		kind:   "playlist",
+		schema: validation.PatchPlaylistSchema,
+		new:    func() interface{} { return &resources.PlayList{} },
+		id:     func(value interface{}) string { return value.(*resources.PlayList).ID },
+		list: func(mixtape *resources.MixTape) (interface{}, error) {
+			return mixtape.GetPlayLists()
+		},
+		get: func(mixtape *resources.MixTape, id string) (interface{}, error) {
+			return mixtape.GetPlayList(id)
+		},
+		add: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.AddPlayList(value.(*resources.PlayList))
+		},
+		replace: func(mixtape *resources.MixTape, value interface{}) error {
+			return mixtape.ReplacePlayList(value.(*resources.PlayList))
+		},
+		remove: func(mixtape *resources.MixTape, id string) error {
+			return mixtape.RemovePlayList(id)
+		},
+	},
+}
+
+type APIServer struct {
+	mutex     sync.RWMutex
+	mixtape   *resources.MixTape
+	writer    data.Writer
+	applyMode data.ApplyMode
+	// The ETag of the mixtape is the generation of the server and the version of the mixtape,
+	// so an ETag of a previous run of the server does not match
+	generation int64
+	version    uint64
+}
+
+// Create an API server of the mixtape. Each change is written to the writer, a nil writer
+// keeps the changes in memory only.
+func NewAPIServer(mixtape *resources.MixTape, writer data.Writer) *APIServer {
+	return &APIServer{
+		mixtape:    mixtape,
+		writer:     writer,
+		generation: time.Now().UnixNano(),
+	}
+}
+
+// Set the apply mode of a JSON patch, the default is transactional
+func (s *APIServer) SetApplyMode(mode data.ApplyMode) {
+	s.applyMode = mode
+}
+
+// The resources are the mixtape document /, /users, /songs and /playlists, /users/{id},
+// /songs/{id} and /playlists/{id}, /playlists/{id}/songs and /playlists/{id}/songs/{index}
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(segments) == 1 && len(segments[0]) == 0 {
+		s.serveDocument(w, r)
+		return
+	}
+
+	collection, ok := apiCollections[segments[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, resources.NewError(resources.CodeNotFound, "Path %v does not exist.", r.URL.Path))
+		return
+	}
+
+	switch {
+	case len(segments) == 1:
+		s.serveCollection(w, r, collection)
+	case len(segments) == 2:
+		s.serveEntity(w, r, collection, segments[1])
+	case len(segments) == 3 && segments[0] == "playlists" && segments[2] == "songs":
+		s.servePlayListSongs(w, r, segments[1])
+	case len(segments) == 4 && segments[0] == "playlists" && segments[2] == "songs":
+		s.servePlayListSong(w, r, segments[1], segments[3])
+	default:
+		writeError(w, http.StatusNotFound, resources.NewError(resources.CodeNotFound, "Path %v does not exist.", r.URL.Path))
+	}
+}
+
+// GET gets the mixtape document, PATCH applies a JSON patch or a merge patch to it
+func (s *APIServer) serveDocument(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Accept-Patch", acceptPatch)
+		s.read(w, func(mixtape *resources.MixTape) (interface{}, error) {
+			return getDocument(mixtape)
+		})
+	case http.MethodPatch:
+		s.servePatch(w, r)
+	default:
+		methodNotAllowed(w, r, "GET, PATCH")
+	}
+}
+
+// Apply the patch of the request body as a changes file is applied, and respond with the
+// result of each change (422 when the changes are not applied). A merge patch is translated
+// into the JSON patch of the current mixtape.
+func (s *APIServer) servePatch(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := patchFormats[mediaType]
+	if !ok {
+		w.Header().Set("Accept-Patch", acceptPatch)
+		writeError(w, http.StatusUnsupportedMediaType, resources.NewError(resources.CodeUnsupported, "The media type of a patch must be %v or %v.", JSONPatchType, MergePatchType))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.checkPrecondition(w, r, true) {
+		return
+	}
+
+	changes, err := data.DecodeChangesFormat(body, format, s.mixtape)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid changes. %w", err))
+		return
+	}
+
+	tx := s.mixtape.Begin()
+	results, err := data.ApplyChanges(tx, changes, s.applyMode)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, struct {
+			Error   string              `json:"error"`
+			Changes []data.ChangeResult `json:"changes"`
+		}{err.Error(), results})
+		return
+	}
+
+	if !s.commit(w, tx) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Changes []data.ChangeResult `json:"changes"`
+	}{results})
+}
+
+// The mixtape document, in the output order
+func getDocument(mixtape *resources.MixTape) (interface{}, error) {
+	var document resources.MixTapeApiModel
+	var err error
+
+	document.Users, err = mixtape.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	document.PlayLists, err = mixtape.GetPlayLists()
+	if err != nil {
+		return nil, err
+	}
+
+	document.Songs, err = mixtape.GetSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// GET lists the collection, POST adds an entity
+func (s *APIServer) serveCollection(w http.ResponseWriter, r *http.Request, collection *apiCollection) {
+	switch r.Method {
+	case http.MethodGet:
+		s.read(w, func(mixtape *resources.MixTape) (interface{}, error) {
+			return collection.list(mixtape)
+		})
+	case http.MethodPost:
+		value := collection.new()
+		if !readValue(w, r, collection.kind, collection.schema, value) {
+			return
+		}
+		created, ok := s.update(w, r, func(mixtape *resources.MixTape) (interface{}, error) {
+			err := collection.add(mixtape, value)
+			if err != nil {
+				return nil, err
+			}
+			return collection.get(mixtape, collection.id(value))
+		})
+		if ok {
+			w.Header().Set("Location", fmt.Sprintf("%v/%v", strings.TrimSuffix(r.URL.Path, "/"), collection.id(value)))
+			writeJSON(w, http.StatusCreated, created)
+		}
+	default:
+		methodNotAllowed(w, r, "GET, POST")
+	}
+}
+
+// GET gets an entity, PUT replaces it and DELETE removes it
+func (s *APIServer) serveEntity(w http.ResponseWriter, r *http.Request, collection *apiCollection, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.read(w, func(mixtape *resources.MixTape) (interface{}, error) {
+			return collection.get(mixtape, id)
+		})
+	case http.MethodPut:
+		value := collection.new()
+		if !readValue(w, r, collection.kind, collection.schema, value) {
+			return
+		}
+		if collection.id(value) != id {
+			writeError(w, http.StatusBadRequest, resources.NewError(resources.CodeInvalidValue,
+				"The %v ID %v does not match the path ID %v.", collection.kind, collection.id(value), id))
+			return
+		}
+		s.respond(w, r, http.StatusOK, func(mixtape *resources.MixTape) (interface{}, error) {
+			err := collection.replace(mixtape, value)
+			if err != nil {
+				return nil, err
+			}
+			return collection.get(mixtape, id)
+		})
+	case http.MethodDelete:
+		s.respond(w, r, http.StatusNoContent, func(mixtape *resources.MixTape) (interface{}, error) {
+			return nil, collection.remove(mixtape, id)
+		})
+	default:
+		methodNotAllowed(w, r, "GET, PUT, DELETE")
+	}
+}
+
+// GET gets the song IDs of a playlist, POST adds a song ID at the end of the playlist, and PUT
+// replaces the song IDs
+func (s *APIServer) servePlayListSongs(w http.ResponseWriter, r *http.Request, playlistID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.read(w, func(mixtape *resources.MixTape) (interface{}, error) {
+			return getPlayListSongs(mixtape, playlistID)
+		})
+	case http.MethodPost:
+		var songID string
+		if !decodeBody(w, r, "song ID", &songID) {
+			return
+		}
+		s.respond(w, r, http.StatusCreated, func(mixtape *resources.MixTape) (interface{}, error) {
+			err := mixtape.AddSongToPlayList(playlistID, songID)
+			if err != nil {
+				return nil, err
+			}
+			return getPlayListSongs(mixtape, playlistID)
+		})
+	case http.MethodPut:
+		var songIDs []string
+		if !decodeBody(w, r, "song IDs", &songIDs) {
+			return
+		}
+		s.respond(w, r, http.StatusOK, func(mixtape *resources.MixTape) (interface{}, error) {
+			playlist, err := mixtape.GetPlayList(playlistID)
+			if err != nil {
+				return nil, err
+			}
+			playlist.SongIDs = songIDs
+			err = mixtape.ReplacePlayList(playlist)
+			if err != nil {
+				return nil, err
+			}
+			return getPlayListSongs(mixtape, playlistID)
+		})
+	default:
+		methodNotAllowed(w, r, "GET, POST, PUT")
+	}
+}
+
+// GET gets the song ID at the index of a playlist, DELETE removes it from the playlist
+func (s *APIServer) servePlayListSong(w http.ResponseWriter, r *http.Request, playlistID, indexToken string) {
+	// An index is 0 or digits without a leading zero, as in a JSON pointer
+	index, err := strconv.Atoi(indexToken)
+	if err != nil || len(strings.Trim(indexToken, "0123456789")) != 0 || (len(indexToken) > 1 && indexToken[0] == '0') {
+		writeError(w, http.StatusNotFound, resources.NewError(resources.CodeNotFound, "Path %v does not exist.", r.URL.Path))
+		return
+	}
+
+	// An index that is out of range is a song that does not exist
+	getSongID := func(mixtape *resources.MixTape) (interface{}, error) {
+		songIDs, err := getPlayListSongs(mixtape, playlistID)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= len(songIDs) {
+			return nil, resources.NewError(resources.CodeNotFound, "Index %v is out of range for playlist ID %v.", index, playlistID)
+		}
+		return songIDs[index], nil
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.read(w, getSongID)
+	case http.MethodDelete:
+		s.respond(w, r, http.StatusNoContent, func(mixtape *resources.MixTape) (interface{}, error) {
+			_, err := getSongID(mixtape)
+			if err != nil {
+				return nil, err
+			}
+			return nil, mixtape.RemoveSongFromPlayList(playlistID, index)
+		})
+	default:
+		methodNotAllowed(w, r, "GET, DELETE")
+	}
+}
+
+func getPlayListSongs(mixtape *resources.MixTape, playlistID string) ([]string, error) {
+	playlist, err := mixtape.GetPlayList(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	return playlist.SongIDs, nil
+}
+
+// Respond with the value read from the mixtape
+func (s *APIServer) read(w http.ResponseWriter, fn func(mixtape *resources.MixTape) (interface{}, error)) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	w.Header().Set("ETag", s.etag())
+
+	value, err := fn(s.mixtape)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, value)
+}
+
+// Change the mixtape in a transaction and respond with the value returned by fn
+func (s *APIServer) respond(w http.ResponseWriter, r *http.Request, status int, fn func(mixtape *resources.MixTape) (interface{}, error)) {
+	value, ok := s.update(w, r, fn)
+	if !ok {
+		return
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, value)
+}
+
+// Change the mixtape in a transaction, when the If-Match precondition of the request holds.
+// The error response is written when the change fails.
+func (s *APIServer) update(w http.ResponseWriter, r *http.Request, fn func(mixtape *resources.MixTape) (interface{}, error)) (interface{}, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.checkPrecondition(w, r, false) {
+		return nil, false
+	}
+
+	tx := s.mixtape.Begin()
+	value, err := fn(tx)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return nil, false
+	}
+
+	if !s.commit(w, tx) {
+		return nil, false
+	}
+	return value, true
+}
+
+// Write the output and commit the transaction, which changes the version of the mixtape. The
+// error response is written when the transaction cannot be committed.
+func (s *APIServer) commit(w http.ResponseWriter, tx *resources.MixTape) bool {
+	if s.writer != nil {
+		err := data.WriteOutput(s.writer, tx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return false
+		}
+	}
+
+	err := tx.Commit()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return false
+	}
+
+	s.version++
+	w.Header().Set("ETag", s.etag())
+	return true
+}
+
+func (s *APIServer) etag() string {
+	return fmt.Sprintf("\"%x-%v\"", s.generation, s.version)
+}
+
+// Check the If-Match header of a change against the ETag of the mixtape, a missing header
+// fails the check when it is required. The error response is written when the check fails.
+func (s *APIServer) checkPrecondition(w http.ResponseWriter, r *http.Request, required bool) bool {
+	etag := s.etag()
+	w.Header().Set("ETag", etag)
+
+	ifMatch := r.Header.Get("If-Match")
+	if len(ifMatch) == 0 {
+		if required {
+			writeError(w, http.StatusPreconditionRequired, resources.NewError(resources.CodePreconditionRequired,
+				"The If-Match header is required, with the ETag of the mixtape."))
+			return false
+		}
+		return true
+	}
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	writeError(w, http.StatusPreconditionFailed, resources.NewError(resources.CodePreconditionFailed,
+		"The mixtape was changed, its ETag is %v.", etag))
+	return false
+}
+
+// Read the request body, validate it against the schema and unmarshal it into value
+func readValue(w http.ResponseWriter, r *http.Request, kind string, schema string, value interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+
+	err = validation.Validate(schema, string(body))
+	if err == nil {
+		err = json.Unmarshal(body, value)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, resources.WrapError(resources.CodeInvalidValue, err, "Invalid %v. %v", kind, err))
+		return false
+	}
+
+	return true
+}
+
+// Unmarshal the request body into value
+func decodeBody(w http.ResponseWriter, r *http.Request, kind string, value interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, resources.NewError(resources.CodeInvalidValue, "Invalid %v. %v", kind, err))
+		return false
+	}
+	return true
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, resources.NewError(resources.CodeUnsupported, "Method %v is not allowed.", r.Method))
+}
+
+// The status of an error of the mixtape, by its code
+func errorStatus(err error) int {
+	switch resources.Code(err) {
+	case resources.CodeNotFound:
+		return http.StatusNotFound
+	case resources.CodeDuplicate, resources.CodeReferenced:
+		return http.StatusConflict
+	case resources.CodeInvalidValue, resources.CodeInvalidPath, resources.CodeOutOfRange, resources.CodeLimit:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"highspot/data"
+	"highspot/resources"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A writer that keeps the last output, or fails with err
+type memoryWriter struct {
+	output []byte
+	writes int
+	err    error
+}
+
+func (w *memoryWriter) Write(data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.output = data
+	w.writes++
+	return nil
+}
+
+// A request to the API server and the expected response
+type apiRequest struct {
+	method string
+	target string
+	body   string
+	status int
+	// A part of the expected response body
+	contains string
+}
+
+func runAPIRequests(t *testing.T, server *APIServer, requests []apiRequest) {
+	for _, request := range requests {
+		w := serve(server, request.method, request.target, request.body, nil)
+		name := request.method + " " + request.target
+		if w.Code != request.status {
+			t.Errorf("%v: expected %v, got %v %v", name, request.status, w.Code, w.Body)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), request.contains) {
+			t.Errorf("%v: expected the body to contain %q, got %v", name, request.contains, w.Body)
+		}
+	}
+}
+
+func TestAPIUsers(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"GET", "/users", "", http.StatusOK, `"name": "Dipika Crescentia"`},
+		{"GET", "/users/1", "", http.StatusOK, `"name": "Albin Jaye"`},
+		{"GET", "/users/9", "", http.StatusNotFound, `"code": "not_found"`},
+		{"POST", "/users", `{"id": "3", "name": "Ana"}`, http.StatusCreated, `"name": "Ana"`},
+		{"POST", "/users", `{"id": "3", "name": "Ana"}`, http.StatusConflict, `"code": "duplicate"`},
+		{"POST", "/users", `{"id": "4"}`, http.StatusBadRequest, `"violations"`},
+		{"POST", "/users", `not json`, http.StatusBadRequest, `"code": "invalid_value"`},
+		{"PUT", "/users/3", `{"id": "3", "name": "Ana B"}`, http.StatusOK, `"name": "Ana B"`},
+		{"PUT", "/users/3", `{"id": "4", "name": "Ana B"}`, http.StatusBadRequest, `does not match`},
+		{"PUT", "/users/9", `{"id": "9", "name": "Nobody"}`, http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/users/1", "", http.StatusConflict, `"code": "referenced"`},
+		{"DELETE", "/users/3", "", http.StatusNoContent, ""},
+		{"DELETE", "/users/3", "", http.StatusNotFound, `"code": "not_found"`},
+		{"PATCH", "/users", "", http.StatusMethodNotAllowed, `"code": "unsupported"`},
+	})
+}
+
+func TestAPISongs(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"GET", "/songs", "", http.StatusOK, `"title": "Pray For Me"`},
+		{"GET", "/songs/2", "", http.StatusOK, `"artist": "Zedd"`},
+		{"GET", "/songs/9", "", http.StatusNotFound, `"code": "not_found"`},
+		{"POST", "/songs", `{"id": "4", "artist": "Drake", "title": "God's Plan"}`, http.StatusCreated, `"title": "God's Plan"`},
+		{"POST", "/songs", `{"id": "4", "artist": "Drake", "title": "God's Plan"}`, http.StatusConflict, `"code": "duplicate"`},
+		{"POST", "/songs", `{"id": "5", "artist": "Drake"}`, http.StatusBadRequest, `"violations"`},
+		{"PUT", "/songs/4", `{"id": "4", "artist": "Drake", "title": "Nice For What"}`, http.StatusOK, `"title": "Nice For What"`},
+		{"PUT", "/songs/9", `{"id": "9", "artist": "Drake", "title": "Nice For What"}`, http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/songs/1", "", http.StatusConflict, `"code": "referenced"`},
+		{"DELETE", "/songs/4", "", http.StatusNoContent, ""},
+		{"DELETE", "/songs/4", "", http.StatusNotFound, `"code": "not_found"`},
+	})
+}
+
+func TestAPIPlayLists(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"GET", "/playlists", "", http.StatusOK, `"user_id": "2"`},
+		{"GET", "/playlists/1", "", http.StatusOK, `"user_id": "1"`},
+		{"GET", "/playlists/9", "", http.StatusNotFound, `"code": "not_found"`},
+		{"POST", "/playlists", `{"id": "3", "user_id": "2", "song_ids": ["2", "3"]}`, http.StatusCreated, `"id": "3"`},
+		{"POST", "/playlists", `{"id": "3", "user_id": "2", "song_ids": ["2"]}`, http.StatusConflict, `"code": "duplicate"`},
+		{"POST", "/playlists", `{"id": "4", "user_id": "2", "song_ids": []}`, http.StatusBadRequest, `"violations"`},
+		{"POST", "/playlists", `{"id": "4", "user_id": "9", "song_ids": ["1"]}`, http.StatusNotFound, `"code": "not_found"`},
+		{"PUT", "/playlists/3", `{"id": "3", "user_id": "1", "song_ids": ["3"]}`, http.StatusOK, `"user_id": "1"`},
+		{"PUT", "/playlists/9", `{"id": "9", "user_id": "1", "song_ids": ["3"]}`, http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/playlists/3", "", http.StatusNoContent, ""},
+		{"DELETE", "/playlists/3", "", http.StatusNotFound, `"code": "not_found"`},
+		{"PATCH", "/playlists/1", "", http.StatusMethodNotAllowed, `"code": "unsupported"`},
+	})
+}
+
+func TestAPIPlayListSongs(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"GET", "/playlists/1/songs", "", http.StatusOK, `"2"`},
+		{"GET", "/playlists/9/songs", "", http.StatusNotFound, `"code": "not_found"`},
+		{"POST", "/playlists/1/songs", `"3"`, http.StatusCreated, `"3"`},
+		{"POST", "/playlists/1/songs", `"3"`, http.StatusConflict, `"code": "duplicate"`},
+		{"POST", "/playlists/1/songs", `3`, http.StatusBadRequest, `"code": "invalid_value"`},
+		{"GET", "/playlists/1/songs/2", "", http.StatusOK, `"3"`},
+		{"GET", "/playlists/1/songs/3", "", http.StatusNotFound, `"code": "not_found"`},
+		{"GET", "/playlists/1/songs/x", "", http.StatusNotFound, `"code": "not_found"`},
+		{"GET", "/playlists/1/songs/+1", "", http.StatusNotFound, `"code": "not_found"`},
+		{"GET", "/playlists/1/songs/01", "", http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/playlists/1/songs/0", "", http.StatusNoContent, ""},
+		{"PUT", "/playlists/1/songs", `["3", "2"]`, http.StatusOK, `"3"`},
+		{"PUT", "/playlists/1/songs", `["3", "3"]`, http.StatusConflict, `"code": "duplicate"`},
+		{"PUT", "/playlists/1/songs", `[]`, http.StatusUnprocessableEntity, `"code": "limit_exceeded"`},
+		{"GET", "/playlists/1", "", http.StatusOK, `"song_ids": [
+    "3",
+    "2"
+  ]`},
+	})
+}
+
+func TestAPIUnknownPath(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"GET", "/artists", "", http.StatusNotFound, `"code": "not_found"`},
+		{"GET", "/users/1/name", "", http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/", "", http.StatusMethodNotAllowed, `"code": "unsupported"`},
+	})
+}
+
+func TestAPIWritesChanges(t *testing.T) {
+	writer := &memoryWriter{}
+	server := NewAPIServer(newTestMixTape(t), writer)
+
+	w := serve(server, http.MethodPost, "/users", `{"id": "3", "name": "Ana"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %v %v", w.Code, w.Body)
+	}
+	if location := w.Header().Get("Location"); location != "/users/3" {
+		t.Errorf("expected the location /users/3, got %v", location)
+	}
+
+	output, err := data.DecodeMixTape(writer.output, resources.IngestStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := output.GetUser("3")
+	if err != nil {
+		t.Fatalf("expected user 3 in the output, got %v", err)
+	}
+	if user.Name != "Ana" {
+		t.Errorf("expected the name Ana in the output, got %v", user.Name)
+	}
+
+	// A failed change is not written
+	serve(server, http.MethodDelete, "/users/1", "", nil)
+	if writer.writes != 1 {
+		t.Errorf("expected 1 write, got %v", writer.writes)
+	}
+}
+
+func TestAPIChangeIsNotMadeWhenTheOutputCannotBeWritten(t *testing.T) {
+	writer := &memoryWriter{err: errors.New("The disk is full.")}
+	server := NewAPIServer(newTestMixTape(t), writer)
+
+	runAPIRequests(t, server, []apiRequest{
+		{"POST", "/users", `{"id": "3", "name": "Ana"}`, http.StatusInternalServerError, "The disk is full."},
+		{"GET", "/users/3", "", http.StatusNotFound, `"code": "not_found"`},
+		{"DELETE", "/songs/3", "", http.StatusInternalServerError, "The disk is full."},
+		{"GET", "/songs/3", "", http.StatusOK, `"title": "Pray For Me"`},
+	})
+}
+
+// The ETag of the mixtape document
+func documentETag(t *testing.T, server *APIServer) string {
+	w := serve(server, http.MethodGet, "/", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /: expected 200, got %v %v", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("GET /: expected an ETag")
+	}
+	return etag
+}
+
+// The results of the changes of a patch response
+func patchResults(t *testing.T, w *httptest.ResponseRecorder) []data.ChangeResult {
+	var response struct {
+		Changes []data.ChangeResult `json:"changes"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.Changes
+}
+
+func TestAPIPatchRequiresIfMatch(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	w := serve(server, http.MethodPatch, "/", `[{"op": "remove", "path": "/playlists/2"}]`, map[string]string{
+		"Content-Type": JSONPatchType,
+	})
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428, got %v %v", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"code": "precondition_required"`) {
+		t.Errorf("expected the code precondition_required, got %v", w.Body)
+	}
+	if w.Header().Get("ETag") != documentETag(t, server) {
+		t.Errorf("expected the ETag of the mixtape in the 428 response, got %v", w.Header().Get("ETag"))
+	}
+}
+
+func TestAPIPatchMediaTypes(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `[{"op": "remove", "path": "/playlists/2"}]`, http.StatusUnsupportedMediaType},
+		{"text/plain", `[{"op": "remove", "path": "/playlists/2"}]`, http.StatusUnsupportedMediaType},
+		{"", `[{"op": "remove", "path": "/playlists/2"}]`, http.StatusUnsupportedMediaType},
+		{JSONPatchType + "; charset=utf-8", `[{"op": "replace", "path": "/users/1/name", "value": "Albin"}]`, http.StatusOK},
+		{MergePatchType, `{"users": {"2": {"name": "Dipika"}}}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		w := serve(server, http.MethodPatch, "/", test.body, map[string]string{
+			"Content-Type": test.contentType,
+			"If-Match":     documentETag(t, server),
+		})
+		if w.Code != test.status {
+			t.Errorf("%q: expected %v, got %v %v", test.contentType, test.status, w.Code, w.Body)
+			continue
+		}
+		if test.status == http.StatusUnsupportedMediaType && w.Header().Get("Accept-Patch") != acceptPatch {
+			t.Errorf("%q: expected the Accept-Patch header %v, got %v", test.contentType, acceptPatch, w.Header().Get("Accept-Patch"))
+		}
+	}
+}
+
+func TestAPIPatchETag(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+	etag := documentETag(t, server)
+
+	patch := func(ifMatch, body string) *httptest.ResponseRecorder {
+		return serve(server, http.MethodPatch, "/", body, map[string]string{
+			"Content-Type": JSONPatchType,
+			"If-Match":     ifMatch,
+		})
+	}
+
+	// The patch with the current ETag is applied, and changes the ETag
+	w := patch(etag, `[{"op": "replace", "path": "/users/1/name", "value": "Albin"}, {"op": "remove", "path": "/playlists/2"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v %v", w.Code, w.Body)
+	}
+	changed := w.Header().Get("ETag")
+	if changed == etag || changed != documentETag(t, server) {
+		t.Errorf("expected a new ETag %v in the response, got %v", documentETag(t, server), changed)
+	}
+
+	results := patchResults(t, w)
+	if len(results) != 2 || results[0].Status != data.Applied || results[1].Status != data.Applied {
+		t.Errorf("expected 2 applied changes, got %+v", results)
+	}
+
+	// The patch with the previous ETag is rejected, the mixtape is unchanged
+	w = patch(etag, `[{"op": "remove", "path": "/playlists/1"}]`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %v %v", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"code": "precondition_failed"`) {
+		t.Errorf("expected the code precondition_failed, got %v", w.Body)
+	}
+	if w := serve(server, http.MethodGet, "/playlists/1", "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected playlist 1 to be kept, got %v", w.Code)
+	}
+
+	// A list of ETags and the * ETag match
+	w = patch(etag+", "+changed, `[{"op": "test", "path": "/users/1/name", "value": "Albin"}]`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with a list of ETags, got %v %v", w.Code, w.Body)
+	}
+	w = patch("*", `[{"op": "test", "path": "/users/1/name", "value": "Albin"}]`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the * ETag, got %v %v", w.Code, w.Body)
+	}
+}
+
+func TestAPIPatchResults(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+	etag := documentETag(t, server)
+
+	w := serve(server, http.MethodPatch, "/", `[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "remove", "path": "/songs/1"},
+		{"op": "remove", "path": "/playlists/2"}
+	]`, map[string]string{
+		"Content-Type": JSONPatchType,
+		"If-Match":     etag,
+	})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %v %v", w.Code, w.Body)
+	}
+
+	expected := []struct {
+		status data.ChangeStatus
+		code   resources.ErrorCode
+	}{
+		{data.Skipped, resources.CodeRolledBack},
+		{data.Failed, resources.CodeReferenced},
+		{data.Skipped, resources.CodeAborted},
+	}
+	results := patchResults(t, w)
+	if len(results) != len(expected) {
+		t.Fatalf("expected %v results, got %+v", len(expected), results)
+	}
+	for i, result := range results {
+		if result.Index != i || result.Status != expected[i].status || result.Code != expected[i].code {
+			t.Errorf("change %v: expected %v %v, got %+v", i, expected[i].status, expected[i].code, result)
+		}
+	}
+
+	// The changes are rolled back, the ETag is unchanged
+	if documentETag(t, server) != etag {
+		t.Errorf("expected the ETag %v to be unchanged", etag)
+	}
+	if w := serve(server, http.MethodGet, "/users/1", "", nil); !strings.Contains(w.Body.String(), "Albin Jaye") {
+		t.Errorf("expected the name of user 1 to be unchanged, got %v", w.Body)
+	}
+}
+
+func TestAPIEntityIfMatch(t *testing.T) {
+	server := NewAPIServer(newTestMixTape(t), nil)
+	etag := documentETag(t, server)
+
+	put := func(ifMatch, name string) *httptest.ResponseRecorder {
+		return serve(server, http.MethodPut, "/users/1", `{"id": "1", "name": "`+name+`"}`, map[string]string{
+			"If-Match": ifMatch,
+		})
+	}
+
+	w := put(etag, "Albin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v %v", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("expected the ETag to change")
+	}
+
+	// The second client read the same ETag, its change is rejected
+	w = put(etag, "Albin J")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %v %v", w.Code, w.Body)
+	}
+	if w := serve(server, http.MethodGet, "/users/1", "", nil); !strings.Contains(w.Body.String(), `"name": "Albin"`) {
+		t.Errorf("expected the first change to be kept, got %v", w.Body)
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"highspot/data"
+	"highspot/resources"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// The feed server serves a mixtape as the paginated feed of the README Scaling Discussion.
+// Each entity of the mixtape is a put in the stream of its collection. A change of an entity
+// appends a put of its new value, or a delete, to the stream, so the feed reflects the
+// changes applied to the mixtape.
+//
+
+// The maximum number of entities in a batch
+const MaxFeedLimit = 1000
+
+// The default number of entities in a batch
+const DefaultFeedLimit = 100
+
+// The order of the stream entries of a change, a new user or song is put before a playlist
+// uses it, and a user or song is deleted after the playlists that use it
+var feedPhases = []struct {
+	collection string
+	deleted    bool
+}{
+	{"users", false},
+	{"songs", false},
+	{"playlists", false},
+	{"playlists", true},
+	{"songs", true},
+	{"users", true},
+}
+
+// An entry of the stream of a collection
+type streamEntry struct {
+	streamID uint64
+	action   string
+	value    interface{}
+}
+
+func (e *streamEntry) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(e.value)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fields["stream"] = map[string]string{
+		"id":     strconv.FormatUint(e.streamID, 10),
+		"action": e.action,
+	}
+	return json.Marshal(fields)
+}
+
+// A collection and the ID of an entity
+type entityKey struct {
+	collection string
+	id         string
+}
+
+type FeedServer struct {
+	mutex     sync.Mutex
+	mixtape   *resources.MixTape
+	applyMode data.ApplyMode
+	streamID  uint64
+	streams   map[string][]*streamEntry
+	touched   []entityKey
+}
+
+// Create a feed server of the mixtape, the stream of each collection starts with its entities
+func NewFeedServer(mixtape *resources.MixTape, applyMode data.ApplyMode) (*FeedServer, error) {
+	server := FeedServer{
+		mixtape:   mixtape,
+		applyMode: applyMode,
+		streams:   map[string][]*streamEntry{},
+	}
+
+	users, err := mixtape.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		server.append("users", "put", user)
+	}
+
+	songs, err := mixtape.GetSongs()
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range songs {
+		server.append("songs", "put", song)
+	}
+
+	playlists, err := mixtape.GetPlayLists()
+	if err != nil {
+		return nil, err
+	}
+	for _, playlist := range playlists {
+		server.append("playlists", "put", playlist)
+	}
+
+	mixtape.AddHook(func(collection, id string) {
+		server.touched = append(server.touched, entityKey{collection, id})
+	})
+
+	return &server, nil
+}
+
+// GET /users, /songs and /playlists serve a batch of the stream of the collection, the entries
+// after the streamId argument, up to the limit argument.
+// POST /changes applies a JSON patch to the mixtape, the response is the result of each change.
+func (s *FeedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case path == "changes" && r.Method == http.MethodPost:
+		s.serveChanges(w, r)
+	case path == "users" || path == "songs" || path == "playlists":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, resources.NewError(resources.CodeUnsupported, "Method %v is not allowed.", r.Method))
+			return
+		}
+		s.serveBatch(w, r, path)
+	default:
+		writeError(w, http.StatusNotFound, resources.NewError(resources.CodeNotFound, "Path %v does not exist.", r.URL.Path))
+	}
+}
+
+func (s *FeedServer) serveBatch(w http.ResponseWriter, r *http.Request, collection string) {
+	query := r.URL.Query()
+
+	cursor := uint64(0)
+	if value := query.Get("streamId"); len(value) != 0 {
+		var err error
+		cursor, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, resources.NewError(resources.CodeInvalidValue, "Invalid streamId %v.", value))
+			return
+		}
+	}
+
+	limit := DefaultFeedLimit
+	if value := query.Get("limit"); len(value) != 0 {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxFeedLimit {
+			writeError(w, http.StatusBadRequest, resources.NewError(resources.CodeInvalidValue, "Invalid limit %v, expected 1 to %v.", value, MaxFeedLimit))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	stream := s.streams[collection]
+	s.mutex.Unlock()
+
+	batch := []*streamEntry{}
+	for _, entry := range stream {
+		if entry.streamID > cursor {
+			batch = append(batch, entry)
+			if len(batch) == limit {
+				break
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, batch)
+}
+
+func (s *FeedServer) serveChanges(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes, err := data.DecodeChanges(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid changes. %w", err))
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.touched = nil
+	results, err := data.ApplyChanges(s.mixtape, changes, s.applyMode)
+	if err == nil || s.applyMode == data.BestEffort {
+		s.appendChanges()
+	}
+	s.touched = nil
+
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, struct {
+			Error   string              `json:"error"`
+			Changes []data.ChangeResult `json:"changes"`
+		}{err.Error(), results})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Changes []data.ChangeResult `json:"changes"`
+	}{results})
+}
+
+// Append an entry for each entity touched by the changes, a put of the entity or a delete
+func (s *FeedServer) appendChanges() {
+	seen := map[entityKey]bool{}
+
+	for _, phase := range feedPhases {
+		for _, key := range s.touched {
+			if key.collection != phase.collection || seen[key] {
+				continue
+			}
+
+			value, err := s.getEntity(key)
+			if (err != nil) != phase.deleted {
+				continue
+			}
+			seen[key] = true
+
+			if phase.deleted {
+				s.append(key.collection, "delete", map[string]string{"id": key.id})
+			} else {
+				s.append(key.collection, "put", value)
+			}
+		}
+	}
+}
+
+func (s *FeedServer) getEntity(key entityKey) (interface{}, error) {
+	switch key.collection {
+	case "users":
+		return s.mixtape.GetUser(key.id)
+	case "songs":
+		return s.mixtape.GetSong(key.id)
+	}
+	return s.mixtape.GetPlayList(key.id)
+}
+
+func (s *FeedServer) append(collection, action string, value interface{}) {
+	s.streamID++
+	s.streams[collection] = append(s.streams[collection], &streamEntry{
+		streamID: s.streamID,
+		action:   action,
+		value:    value,
+	})
+}
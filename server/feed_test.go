@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"highspot/data"
+	"highspot/resources"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A mixtape of two users, three songs and two playlists
+const testMixTape = `{
+  "users": [
+    {"id": "1", "name": "Albin Jaye"},
+    {"id": "2", "name": "Dipika Crescentia"}
+  ],
+  "playlists": [
+    {"id": "1", "user_id": "1", "song_ids": ["1", "2"]},
+    {"id": "2", "user_id": "2", "song_ids": ["1"]}
+  ],
+  "songs": [
+    {"id": "1", "artist": "Camila Cabello", "title": "Never Be the Same"},
+    {"id": "2", "artist": "Zedd", "title": "The Middle"},
+    {"id": "3", "artist": "The Weeknd", "title": "Pray For Me"}
+  ]
+}`
+
+func newTestMixTape(t *testing.T) *resources.MixTape {
+	mixtape, err := data.DecodeMixTape([]byte(testMixTape), resources.IngestStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mixtape
+}
+
+// Serve the request and return the response
+func serve(handler http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// The stream of a collection of the feed server, as stream actions and IDs
+func readStream(t *testing.T, server *FeedServer, collection string) []string {
+	w := serve(server, http.MethodGet, "/"+collection+"?limit=1000", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /%v: expected 200, got %v %v", collection, w.Code, w.Body)
+	}
+
+	var batch []struct {
+		ID     string `json:"id"`
+		Stream struct {
+			Action string `json:"action"`
+		} `json:"stream"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := []string{}
+	for _, entry := range batch {
+		stream = append(stream, entry.Stream.Action+" "+entry.ID)
+	}
+	return stream
+}
+
+func TestFeedServerStreamsChanges(t *testing.T) {
+	server, err := NewFeedServer(newTestMixTape(t), data.Transactional)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := `[
+		{"op": "replace", "path": "/users/1/name", "value": "Albin"},
+		{"op": "remove", "path": "/playlists/2"},
+		{"op": "remove", "path": "/songs/3"}
+	]`
+	w := serve(server, http.MethodPost, "/changes", changes, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v %v", w.Code, w.Body)
+	}
+
+	expected := map[string]string{
+		"users":     "[put 1 put 2 put 1]",
+		"songs":     "[put 1 put 2 put 3 delete 3]",
+		"playlists": "[put 1 put 2 delete 2]",
+	}
+	for collection, stream := range expected {
+		if actual := fmt.Sprint(readStream(t, server, collection)); actual != stream {
+			t.Errorf("%v: expected the stream %v, got %v", collection, stream, actual)
+		}
+	}
+}
+
+func TestFeedServerFailedChangeEmitsNoEntries(t *testing.T) {
+	//
+	// The move removes song 1 from playlist 1, then fails to insert it in playlist 2, which
+	// already has it. The removal is discarded with the change, and is not in the stream.
+	//
+	changes := `[
+		{"op": "move", "from": "/playlists/1/song_ids/0", "path": "/playlists/2/song_ids/0"},
+		{"op": "replace", "path": "/users/2/name", "value": "Dipika"}
+	]`
+
+	tests := []struct {
+		mode      data.ApplyMode
+		status    int
+		users     string
+		playlists string
+	}{
+		{data.BestEffort, http.StatusOK, "[put 1 put 2 put 2]", "[put 1 put 2]"},
+		{data.Transactional, http.StatusUnprocessableEntity, "[put 1 put 2]", "[put 1 put 2]"},
+	}
+
+	for _, test := range tests {
+		server, err := NewFeedServer(newTestMixTape(t), test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := serve(server, http.MethodPost, "/changes", changes, nil)
+		if w.Code != test.status {
+			t.Fatalf("mode %v: expected %v, got %v %v", test.mode, test.status, w.Code, w.Body)
+		}
+
+		if actual := fmt.Sprint(readStream(t, server, "playlists")); actual != test.playlists {
+			t.Errorf("mode %v: expected the playlists stream %v, got %v", test.mode, test.playlists, actual)
+		}
+		if actual := fmt.Sprint(readStream(t, server, "users")); actual != test.users {
+			t.Errorf("mode %v: expected the users stream %v, got %v", test.mode, test.users, actual)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"highspot/data/validation"
+	"highspot/resources"
+	"log"
+	"net/http"
+)
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		log.Printf("Cannot write response. %v", err)
+		http.Error(w, "Cannot write response.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Write the error, its code and its schema violations
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error      string                 `json:"error"`
+		Code       resources.ErrorCode    `json:"code"`
+		Violations []validation.Violation `json:"violations,omitempty"`
+	}{err.Error(), resources.Code(err), validation.Violations(err)})
+}